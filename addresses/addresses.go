@@ -0,0 +1,141 @@
+// Package addresses normalizes postal addresses and validates them against
+// the rules of their country.
+package addresses
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+// Rule describes what a valid address looks like in one country.
+type Rule struct {
+	Name string
+	// Postcode matches a normalized postcode; nil accepts any.
+	Postcode         *regexp.Regexp
+	PostcodeRequired bool
+	PostcodeExample  string
+	StateRequired    bool
+	// StateCodes are upper-cased, as in the US "CA" or the Australian "NSW".
+	StateCodes bool
+	// PostcodeSpace puts the space of postcodes such as "SW1A 1AA" back
+	// before the last PostcodeSpace characters.
+	PostcodeSpace int
+}
+
+// rules is the local rules table, keyed by ISO 3166-1 alpha-2 country code.
+// Countries not listed only need a street and a city.
+var rules = map[string]Rule{
+	"AU": {Name: "Australia", Postcode: regexp.MustCompile(`^\d{4}$`), PostcodeRequired: true, PostcodeExample: "2000", StateRequired: true, StateCodes: true},
+	"CA": {Name: "Canada", Postcode: regexp.MustCompile(`^[A-Z]\d[A-Z] \d[A-Z]\d$`), PostcodeRequired: true, PostcodeExample: "K1A 0B1", StateRequired: true, StateCodes: true, PostcodeSpace: 3},
+	"DE": {Name: "Germany", Postcode: regexp.MustCompile(`^\d{5}$`), PostcodeRequired: true, PostcodeExample: "10115"},
+	"FR": {Name: "France", Postcode: regexp.MustCompile(`^\d{5}$`), PostcodeRequired: true, PostcodeExample: "75001"},
+	"GB": {Name: "United Kingdom", Postcode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`), PostcodeRequired: true, PostcodeExample: "SW1A 1AA", PostcodeSpace: 3},
+	"IN": {Name: "India", Postcode: regexp.MustCompile(`^[1-9]\d{5}$`), PostcodeRequired: true, PostcodeExample: "110001", StateRequired: true},
+	"NG": {Name: "Nigeria", Postcode: regexp.MustCompile(`^\d{6}$`), PostcodeExample: "100001", StateRequired: true},
+	"US": {Name: "United States", Postcode: regexp.MustCompile(`^\d{5}(-\d{4})?$`), PostcodeRequired: true, PostcodeExample: "94105", StateRequired: true, StateCodes: true},
+}
+
+var countryCode = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// FieldError reports a problem with one field, named as in the JSON body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// collapse trims a value and squeezes runs of whitespace into single spaces.
+func collapse(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// recase title-cases values typed all in lower or all in upper case, and
+// leaves deliberate mixed casing such as "McAllen" alone.
+func recase(value string) string {
+	if value != strings.ToLower(value) && value != strings.ToUpper(value) {
+		return value
+	}
+
+	words := strings.Fields(strings.ToLower(value))
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+func normalizeField(field **string, format func(string) string) {
+	if *field == nil {
+		return
+	}
+	value := format(collapse(**field))
+	if value == "" {
+		*field = nil
+		return
+	}
+	*field = &value
+}
+
+// Normalize cleans up the casing and whitespace of an address in place.
+func Normalize(address *models.Address) {
+	address.Label = collapse(address.Label)
+	address.Country = strings.ToUpper(collapse(address.Country))
+	rule := rules[address.Country]
+
+	normalizeField(&address.House, func(value string) string { return value })
+	normalizeField(&address.Street, recase)
+	normalizeField(&address.City, recase)
+	normalizeField(&address.State, func(value string) string {
+		if rule.StateCodes {
+			return strings.ToUpper(value)
+		}
+		return recase(value)
+	})
+	normalizeField(&address.Pincode, func(value string) string {
+		value = strings.ToUpper(value)
+		if rule.PostcodeSpace > 0 {
+			value = strings.ReplaceAll(value, " ", "")
+			if len(value) > rule.PostcodeSpace {
+				value = value[:len(value)-rule.PostcodeSpace] + " " + value[len(value)-rule.PostcodeSpace:]
+			}
+		}
+		return value
+	})
+}
+
+// Validate checks a normalized address against the rules of its country and
+// returns every problem found.
+func Validate(address models.Address) []FieldError {
+	var problems []FieldError
+	fail := func(field, format string, args ...interface{}) {
+		problems = append(problems, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !countryCode.MatchString(address.Country) {
+		fail("country", "must be a two letter ISO country code such as NG or US")
+	}
+	rule, known := rules[address.Country]
+
+	if address.Street == nil {
+		fail("street_name", "is required")
+	}
+	if address.City == nil {
+		fail("city_name", "is required")
+	}
+	if address.State == nil && rule.StateRequired {
+		fail("state", "is required in %s", rule.Name)
+	}
+
+	switch {
+	case address.Pincode == nil && rule.PostcodeRequired:
+		fail("pin_code", "is required in %s", rule.Name)
+	case address.Pincode != nil && known && rule.Postcode != nil && !rule.Postcode.MatchString(*address.Pincode):
+		fail("pin_code", "is not a valid postcode in %s, expected something like %s", rule.Name, rule.PostcodeExample)
+	}
+
+	return problems
+}
@@ -0,0 +1,109 @@
+package addresses
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+func text(value string) *string {
+	return &value
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   models.Address
+		want models.Address
+	}{
+		{
+			name: "british postcode, shouted city",
+			in:   models.Address{Label: "  home ", Country: " gb", House: text("10"), Street: text("downing   st"), City: text("LONDON"), Pincode: text("sw1a1aa")},
+			want: models.Address{Label: "home", Country: "GB", House: text("10"), Street: text("Downing St"), City: text("London"), Pincode: text("SW1A 1AA")},
+		},
+		{
+			name: "state codes and mixed case names are kept as typed",
+			in:   models.Address{Country: "us", Street: text("Market St"), City: text("McAllen"), State: text("tx"), Pincode: text(" 78501 ")},
+			want: models.Address{Country: "US", Street: text("Market St"), City: text("McAllen"), State: text("TX"), Pincode: text("78501")},
+		},
+		{
+			name: "blank fields become missing",
+			in:   models.Address{Country: "NG", House: text("   "), Street: text("allen ave"), City: text("ikeja"), State: text("lagos"), Pincode: text("")},
+			want: models.Address{Country: "NG", Street: text("Allen Ave"), City: text("Ikeja"), State: text("Lagos")},
+		},
+		{
+			name: "canadian postcode spacing",
+			in:   models.Address{Country: "CA", Pincode: text("k1a0b1")},
+			want: models.Address{Country: "CA", Pincode: text("K1A 0B1")},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			address := test.in
+			Normalize(&address)
+			if !reflect.DeepEqual(address, test.want) {
+				t.Errorf("Normalize() = %s, want %s", describe(address), describe(test.want))
+			}
+		})
+	}
+}
+
+func describe(address models.Address) string {
+	value := func(field *string) string {
+		if field == nil {
+			return "<nil>"
+		}
+		return "\"" + *field + "\""
+	}
+	return "{" + address.Label + " " + value(address.House) + " " + value(address.Street) + " " + value(address.City) + " " + value(address.State) + " " + value(address.Pincode) + " " + address.Country + "}"
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		address models.Address
+		fields  []string
+	}{
+		{
+			name:    "valid american address",
+			address: models.Address{Country: "US", Street: text("Market St"), City: text("San Francisco"), State: text("CA"), Pincode: text("94105-1234")},
+		},
+		{
+			name:    "american address without state or zip",
+			address: models.Address{Country: "US", Street: text("Market St"), City: text("San Francisco")},
+			fields:  []string{"state", "pin_code"},
+		},
+		{
+			name:    "malformed postcode",
+			address: models.Address{Country: "GB", Street: text("Downing St"), City: text("London"), Pincode: text("SW1A")},
+			fields:  []string{"pin_code"},
+		},
+		{
+			name:    "optional postcode in nigeria",
+			address: models.Address{Country: "NG", Street: text("Allen Ave"), City: text("Ikeja"), State: text("Lagos")},
+		},
+		{
+			name:    "country outside the table only needs street and city",
+			address: models.Address{Country: "ZA", Street: text("Long St"), City: text("Cape Town"), Pincode: text("anything")},
+		},
+		{
+			name:    "missing country, street and city",
+			address: models.Address{},
+			fields:  []string{"country", "street_name", "city_name"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var fields []string
+			for _, problem := range Validate(test.address) {
+				fields = append(fields, problem.Field)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("Validate() reported %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Command migrate-orders moves the orders embedded in user documents into the
+// orders collection. It reads the same configuration as the server and is
+// safe to run more than once.
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/database"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Storage != "mongo" {
+		log.Fatalf("nothing to migrate with %s storage", cfg.Storage)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	client, err := database.Connect(ctx, cfg.Mongo)
+	if err != nil {
+		log.Fatalf("cannot connect to the database: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	if err := database.CreateIndexes(ctx, client, cfg.Mongo.Database); err != nil {
+		log.Fatalf("cannot create database indexes: %v", err)
+	}
+
+	moved, err := database.MigrateEmbeddedOrders(ctx, client, cfg.Mongo.Database)
+	if err != nil {
+		log.Fatalf("migration stopped after moving %d orders: %v", moved, err)
+	}
+
+	log.Printf("moved %d orders into their own collection", moved)
+}
@@ -0,0 +1,566 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// Duration accepts Go duration strings such as "24h" or "90s" in config files.
+type Duration time.Duration
+
+func (d *Duration) set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+	return d.set(value)
+}
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+type Mongo struct {
+	URI             string   `json:"uri" yaml:"uri"`
+	Username        string   `json:"username" yaml:"username"`
+	Password        string   `json:"password" yaml:"password"`
+	Database        string   `json:"database" yaml:"database"`
+	ConnectTimeout  Duration `json:"connect_timeout" yaml:"connect_timeout"`
+	ConnectAttempts int      `json:"connect_attempts" yaml:"connect_attempts"`
+	ConnectBackoff  Duration `json:"connect_backoff" yaml:"connect_backoff"`
+}
+
+type JWT struct {
+	Secret        string   `json:"secret" yaml:"secret"`
+	RefreshSecret string   `json:"refresh_secret" yaml:"refresh_secret"`
+	AccessTTL     Duration `json:"access_ttl" yaml:"access_ttl"`
+	RefreshTTL    Duration `json:"refresh_ttl" yaml:"refresh_ttl"`
+}
+
+type Server struct {
+	ReadTimeout     Duration `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+}
+
+type Discount struct {
+	MinSubtotal int     `json:"min_subtotal" yaml:"min_subtotal"`
+	Percent     float64 `json:"percent" yaml:"percent"`
+}
+
+const (
+	TaxExclusive = "exclusive"
+	TaxInclusive = "inclusive"
+)
+
+// TaxRegion sets the rates of one country, or of one state of it when State
+// is set. Categories override Rate for products of that category.
+type TaxRegion struct {
+	Country    string             `json:"country" yaml:"country"`
+	State      string             `json:"state" yaml:"state"`
+	Rate       float64            `json:"rate" yaml:"rate"`
+	Categories map[string]float64 `json:"categories" yaml:"categories"`
+}
+
+type Pricing struct {
+	// TaxRate applies wherever no region or category rate does.
+	TaxRate float64 `json:"tax_rate" yaml:"tax_rate"`
+	// TaxMode says whether product prices exclude tax, which is then added
+	// on top, or already include it.
+	TaxMode       string             `json:"tax_mode" yaml:"tax_mode"`
+	TaxCategories map[string]float64 `json:"tax_categories" yaml:"tax_categories"`
+	TaxRegions    []TaxRegion        `json:"tax_regions" yaml:"tax_regions"`
+	Discounts     []Discount         `json:"discounts" yaml:"discounts"`
+}
+
+type Cart struct {
+	MaxQuantityPerLine int `json:"max_quantity_per_line" yaml:"max_quantity_per_line"`
+}
+
+type Addresses struct {
+	MaxPerUser int `json:"max_per_user" yaml:"max_per_user"`
+}
+
+type Checkout struct {
+	// IdempotencyWindow is how long a checkout's Idempotency-Key is
+	// remembered and its response replayed.
+	IdempotencyWindow Duration `json:"idempotency_window" yaml:"idempotency_window"`
+}
+
+type Inventory struct {
+	// ReservationTTL is how long cart lines hold stock; zero disables holds
+	// and stock is only taken at checkout.
+	ReservationTTL Duration `json:"reservation_ttl" yaml:"reservation_ttl"`
+	SweepInterval  Duration `json:"sweep_interval" yaml:"sweep_interval"`
+}
+
+type Payments struct {
+	// Provider is the gateway digital payments go through; empty only allows
+	// cash on delivery.
+	Provider      string `json:"provider" yaml:"provider"`
+	WebhookSecret string `json:"webhook_secret" yaml:"webhook_secret"`
+}
+
+// ShippingRate prices parcels up to MaxWeight grams; a MaxWeight of zero
+// covers any weight and must come last.
+type ShippingRate struct {
+	MaxWeight int `json:"max_weight" yaml:"max_weight"`
+	Price     int `json:"price" yaml:"price"`
+}
+
+type ShippingOption struct {
+	Name  string         `json:"name" yaml:"name"`
+	Days  int            `json:"days" yaml:"days"`
+	Rates []ShippingRate `json:"rates" yaml:"rates"`
+}
+
+// ShippingZone groups the countries sharing the same rates. A zone without
+// countries covers every country no other zone lists.
+type ShippingZone struct {
+	Name      string           `json:"name" yaml:"name"`
+	Countries []string         `json:"countries" yaml:"countries"`
+	Options   []ShippingOption `json:"options" yaml:"options"`
+}
+
+type Shipping struct {
+	// Zones is the rate table; without zones standard shipping is free.
+	Zones []ShippingZone `json:"zones" yaml:"zones"`
+}
+
+type Config struct {
+	Port           string    `json:"port" yaml:"port"`
+	Server         Server    `json:"server" yaml:"server"`
+	Storage        string    `json:"storage" yaml:"storage"`
+	Mongo          Mongo     `json:"mongo" yaml:"mongo"`
+	JWT            JWT       `json:"jwt" yaml:"jwt"`
+	BcryptCost     int       `json:"bcrypt_cost" yaml:"bcrypt_cost"`
+	RequestTimeout Duration  `json:"request_timeout" yaml:"request_timeout"`
+	AdminEmails    []string  `json:"admin_emails" yaml:"admin_emails"`
+	Pricing        Pricing   `json:"pricing" yaml:"pricing"`
+	Cart           Cart      `json:"cart" yaml:"cart"`
+	Addresses      Addresses `json:"addresses" yaml:"addresses"`
+	Checkout       Checkout  `json:"checkout" yaml:"checkout"`
+	Inventory      Inventory `json:"inventory" yaml:"inventory"`
+	Payments       Payments  `json:"payments" yaml:"payments"`
+	Shipping       Shipping  `json:"shipping" yaml:"shipping"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port:    "8080",
+		Storage: "mongo",
+		Server: Server{
+			ReadTimeout:     Duration(15 * time.Second),
+			WriteTimeout:    Duration(120 * time.Second),
+			IdleTimeout:     Duration(60 * time.Second),
+			ShutdownTimeout: Duration(110 * time.Second),
+		},
+		Mongo: Mongo{
+			URI:             "mongodb://localhost:27017",
+			Database:        "Ecommerce",
+			ConnectTimeout:  Duration(10 * time.Second),
+			ConnectAttempts: 5,
+			ConnectBackoff:  Duration(time.Second),
+		},
+		JWT: JWT{
+			AccessTTL:  Duration(24 * time.Hour),
+			RefreshTTL: Duration(168 * time.Hour),
+		},
+		BcryptCost:     14,
+		RequestTimeout: Duration(100 * time.Second),
+		Pricing: Pricing{
+			TaxMode: TaxExclusive,
+		},
+		Cart: Cart{
+			MaxQuantityPerLine: 10,
+		},
+		Addresses: Addresses{
+			MaxPerUser: 5,
+		},
+		Checkout: Checkout{
+			IdempotencyWindow: Duration(24 * time.Hour),
+		},
+		Inventory: Inventory{
+			SweepInterval: Duration(time.Minute),
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, then the file named by
+// CONFIG_FILE (YAML or JSON, picked by extension) if set, then environment
+// variables, and validates the result.
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	if cfg.JWT.RefreshSecret == "" {
+		cfg.JWT.RefreshSecret = cfg.JWT.Secret
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: cannot read CONFIG_FILE: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, cfg)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(cfg)
+	default:
+		return fmt.Errorf("config: CONFIG_FILE %q must be a .yaml, .yml or .json file", path)
+	}
+	if err != nil {
+		return fmt.Errorf("config: cannot parse %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	strs := map[string]*string{
+		"PORT":                   &cfg.Port,
+		"STORAGE":                &cfg.Storage,
+		"MONGO_URI":              &cfg.Mongo.URI,
+		"MONGO_USERNAME":         &cfg.Mongo.Username,
+		"MONGO_PASSWORD":         &cfg.Mongo.Password,
+		"MONGO_DATABASE":         &cfg.Mongo.Database,
+		"SECRET_KEY":             &cfg.JWT.Secret,
+		"REFRESH_SECRET_KEY":     &cfg.JWT.RefreshSecret,
+		"PAYMENT_PROVIDER":       &cfg.Payments.Provider,
+		"PAYMENT_WEBHOOK_SECRET": &cfg.Payments.WebhookSecret,
+		"TAX_MODE":               &cfg.Pricing.TaxMode,
+	}
+	for name, field := range strs {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"MONGO_CONNECT_TIMEOUT": &cfg.Mongo.ConnectTimeout,
+		"MONGO_CONNECT_BACKOFF": &cfg.Mongo.ConnectBackoff,
+		"ACCESS_TOKEN_TTL":      &cfg.JWT.AccessTTL,
+		"REFRESH_TOKEN_TTL":     &cfg.JWT.RefreshTTL,
+		"REQUEST_TIMEOUT":       &cfg.RequestTimeout,
+		"READ_TIMEOUT":          &cfg.Server.ReadTimeout,
+		"WRITE_TIMEOUT":         &cfg.Server.WriteTimeout,
+		"IDLE_TIMEOUT":          &cfg.Server.IdleTimeout,
+		"SHUTDOWN_TIMEOUT":      &cfg.Server.ShutdownTimeout,
+		"RESERVATION_TTL":       &cfg.Inventory.ReservationTTL,
+		"IDEMPOTENCY_WINDOW":    &cfg.Checkout.IdempotencyWindow,
+		"RESERVATION_SWEEP":     &cfg.Inventory.SweepInterval,
+	}
+	for name, field := range durations {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := field.set(value); err != nil {
+				return fmt.Errorf("config: %s must be a duration such as 30s or 24h: %w", name, err)
+			}
+		}
+	}
+
+	ints := map[string]*int{
+		"BCRYPT_COST":            &cfg.BcryptCost,
+		"MONGO_CONNECT_ATTEMPTS": &cfg.Mongo.ConnectAttempts,
+		"CART_MAX_QUANTITY":      &cfg.Cart.MaxQuantityPerLine,
+		"ADDRESS_LIMIT":          &cfg.Addresses.MaxPerUser,
+	}
+	for name, field := range ints {
+		if value, ok := os.LookupEnv(name); ok {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("config: %s must be a number: %w", name, err)
+			}
+			*field = number
+		}
+	}
+
+	if value, ok := os.LookupEnv("TAX_RATE"); ok {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("config: TAX_RATE must be a number: %w", err)
+		}
+		cfg.Pricing.TaxRate = rate
+	}
+
+	if value, ok := os.LookupEnv("ADMIN_EMAILS"); ok {
+		cfg.AdminEmails = nil
+		for _, email := range strings.Split(value, ",") {
+			if email = strings.TrimSpace(email); email != "" {
+				cfg.AdminEmails = append(cfg.AdminEmails, email)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every problem at once so a broken deployment can be fixed
+// in a single pass.
+func (cfg *Config) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if cfg.Port == "" {
+		fail("PORT (port) must be set")
+	}
+
+	switch cfg.Storage {
+	case "mongo":
+		if cfg.Mongo.URI == "" {
+			fail("MONGO_URI (mongo.uri) must be set when STORAGE is mongo")
+		}
+		if cfg.Mongo.Database == "" {
+			fail("MONGO_DATABASE (mongo.database) must be set when STORAGE is mongo")
+		}
+		if (cfg.Mongo.Username == "") != (cfg.Mongo.Password == "") {
+			fail("MONGO_USERNAME and MONGO_PASSWORD (mongo.username, mongo.password) must be set together")
+		}
+		if cfg.Mongo.ConnectTimeout <= 0 {
+			fail("MONGO_CONNECT_TIMEOUT (mongo.connect_timeout) must be positive")
+		}
+		if cfg.Mongo.ConnectAttempts < 1 {
+			fail("MONGO_CONNECT_ATTEMPTS (mongo.connect_attempts) must be at least 1")
+		}
+		if cfg.Mongo.ConnectBackoff < 0 {
+			fail("MONGO_CONNECT_BACKOFF (mongo.connect_backoff) must not be negative")
+		}
+	case "memory":
+	default:
+		fail("STORAGE (storage) must be mongo or memory, got %q", cfg.Storage)
+	}
+
+	if cfg.JWT.Secret == "" {
+		fail("SECRET_KEY (jwt.secret) must be set")
+	}
+	if cfg.JWT.AccessTTL <= 0 {
+		fail("ACCESS_TOKEN_TTL (jwt.access_ttl) must be positive")
+	}
+	if cfg.JWT.RefreshTTL <= cfg.JWT.AccessTTL {
+		fail("REFRESH_TOKEN_TTL (jwt.refresh_ttl) must be longer than ACCESS_TOKEN_TTL")
+	}
+
+	if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+		fail("BCRYPT_COST (bcrypt_cost) must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cfg.BcryptCost)
+	}
+
+	if cfg.RequestTimeout <= 0 {
+		fail("REQUEST_TIMEOUT (request_timeout) must be positive")
+	}
+
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"READ_TIMEOUT (server.read_timeout)", cfg.Server.ReadTimeout},
+		{"WRITE_TIMEOUT (server.write_timeout)", cfg.Server.WriteTimeout},
+		{"IDLE_TIMEOUT (server.idle_timeout)", cfg.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT (server.shutdown_timeout)", cfg.Server.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			fail("%s must be positive", timeout.name)
+		}
+	}
+
+	// a shorter grace period would close the database under handlers that
+	// are still allowed to run
+	if cfg.Server.ShutdownTimeout < cfg.RequestTimeout {
+		fail("SHUTDOWN_TIMEOUT (server.shutdown_timeout) must be at least REQUEST_TIMEOUT (request_timeout)")
+	}
+
+	if cfg.Cart.MaxQuantityPerLine < 1 {
+		fail("CART_MAX_QUANTITY (cart.max_quantity_per_line) must be at least 1")
+	}
+
+	if cfg.Addresses.MaxPerUser < 1 {
+		fail("ADDRESS_LIMIT (addresses.max_per_user) must be at least 1")
+	}
+
+	if cfg.Checkout.IdempotencyWindow <= 0 {
+		fail("IDEMPOTENCY_WINDOW (checkout.idempotency_window) must be positive")
+	}
+
+	if cfg.Inventory.ReservationTTL < 0 {
+		fail("RESERVATION_TTL (inventory.reservation_ttl) must not be negative")
+	}
+	if cfg.Inventory.ReservationTTL > 0 && cfg.Inventory.SweepInterval <= 0 {
+		fail("RESERVATION_SWEEP (inventory.sweep_interval) must be positive when reservations are enabled")
+	}
+
+	switch cfg.Payments.Provider {
+	case "":
+	case "fake":
+		if cfg.Payments.WebhookSecret == "" {
+			fail("PAYMENT_WEBHOOK_SECRET (payments.webhook_secret) must be set when PAYMENT_PROVIDER is set")
+		}
+	default:
+		fail("PAYMENT_PROVIDER (payments.provider) must be empty or fake, got %q", cfg.Payments.Provider)
+	}
+
+	cfg.validateShipping(fail)
+
+	if cfg.Pricing.TaxRate < 0 || cfg.Pricing.TaxRate >= 100 {
+		fail("TAX_RATE (pricing.tax_rate) must be a percentage between 0 and 100, got %v", cfg.Pricing.TaxRate)
+	}
+	switch cfg.Pricing.TaxMode {
+	case TaxExclusive, TaxInclusive:
+	default:
+		fail("TAX_MODE (pricing.tax_mode) must be %s or %s, got %q", TaxExclusive, TaxInclusive, cfg.Pricing.TaxMode)
+	}
+	validRate := func(name string, rate float64) {
+		if rate < 0 || rate >= 100 {
+			fail("%s must be a percentage between 0 and 100, got %v", name, rate)
+		}
+	}
+	for category, rate := range cfg.Pricing.TaxCategories {
+		validRate(fmt.Sprintf("pricing.tax_categories.%s", category), rate)
+	}
+	regions := map[string]bool{}
+	for i, region := range cfg.Pricing.TaxRegions {
+		name := fmt.Sprintf("pricing.tax_regions[%d]", i)
+		if len(region.Country) != 2 || strings.ToUpper(region.Country) != region.Country {
+			fail("%s.country must be an upper case two letter ISO code, got %q", name, region.Country)
+		}
+		key := region.Country + "/" + strings.ToUpper(region.State)
+		if regions[key] {
+			fail("%s repeats the region %s", name, key)
+		}
+		regions[key] = true
+		validRate(name+".rate", region.Rate)
+		for category, rate := range region.Categories {
+			validRate(fmt.Sprintf("%s.categories.%s", name, category), rate)
+		}
+	}
+	for i, discount := range cfg.Pricing.Discounts {
+		if discount.MinSubtotal < 0 {
+			fail("pricing.discounts[%d].min_subtotal must not be negative", i)
+		}
+		if discount.Percent <= 0 || discount.Percent > 100 {
+			fail("pricing.discounts[%d].percent must be above 0 and at most 100, got %v", i, discount.Percent)
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  - " + strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
+
+func (cfg *Config) validateShipping(fail func(format string, args ...interface{})) {
+	countries := map[string]string{}
+	fallback := ""
+
+	for i, zone := range cfg.Shipping.Zones {
+		name := fmt.Sprintf("shipping.zones[%d]", i)
+		if zone.Name == "" {
+			fail("%s.name must be set", name)
+		}
+
+		if len(zone.Countries) == 0 {
+			if fallback != "" {
+				fail("%s and %s both have no countries, only one zone can cover the rest of the world", fallback, name)
+			}
+			fallback = name
+		}
+		for _, country := range zone.Countries {
+			if len(country) != 2 || strings.ToUpper(country) != country {
+				fail("%s.countries must be upper case two letter ISO codes, got %q", name, country)
+			}
+			if other, ok := countries[country]; ok {
+				fail("%s and %s both list %s", other, name, country)
+			}
+			countries[country] = name
+		}
+
+		if len(zone.Options) == 0 {
+			fail("%s.options must not be empty", name)
+		}
+		seen := map[string]bool{}
+		for j, option := range zone.Options {
+			optionName := fmt.Sprintf("%s.options[%d]", name, j)
+			switch option.Name {
+			case "standard", "express":
+			default:
+				fail("%s.name must be standard or express, got %q", optionName, option.Name)
+			}
+			if seen[option.Name] {
+				fail("%s.name %q is listed twice", optionName, option.Name)
+			}
+			seen[option.Name] = true
+
+			if option.Days < 0 {
+				fail("%s.days must not be negative", optionName)
+			}
+			if len(option.Rates) == 0 {
+				fail("%s.rates must not be empty", optionName)
+			}
+			previous := 0
+			for k, rate := range option.Rates {
+				rateName := fmt.Sprintf("%s.rates[%d]", optionName, k)
+				if rate.Price < 0 {
+					fail("%s.price must not be negative", rateName)
+				}
+				switch {
+				case rate.MaxWeight < 0:
+					fail("%s.max_weight must not be negative", rateName)
+				case rate.MaxWeight == 0 && k != len(option.Rates)-1:
+					fail("%s.max_weight of 0 covers any weight and must be the last rate", rateName)
+				case rate.MaxWeight != 0 && rate.MaxWeight <= previous:
+					fail("%s.max_weight must be larger than the previous rate's", rateName)
+				}
+				previous = rate.MaxWeight
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/payments"
+	"github.com/fredele20/e-commerce-cart/pricing"
+	"github.com/fredele20/e-commerce-cart/shipping"
+	"github.com/fredele20/e-commerce-cart/tokens"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Container holds the fully wired dependencies of the service. Nothing is
+// connected at package init; everything is built here from the config.
+type Container struct {
+	Config   *config.Config
+	Store    *database.Store
+	Tokens   *tokens.Manager
+	Pricing  *pricing.Calculator
+	Shipping *shipping.Calculator
+	// Payments is nil when digital payments are disabled.
+	Payments payments.Provider
+
+	mongoClient *mongo.Client
+}
+
+func New(ctx context.Context, cfg *config.Config) (*Container, error) {
+	c := &Container{Config: cfg}
+
+	switch cfg.Storage {
+	case "memory":
+		log.Println("using the in-memory store, data will not be persisted")
+		c.Store = database.NewMemoryStore()
+	case "mongo":
+		client, err := database.Connect(ctx, cfg.Mongo)
+		if err != nil {
+			return nil, err
+		}
+		c.mongoClient = client
+		if err := database.CheckTransactions(ctx, client); err != nil {
+			_ = client.Disconnect(context.Background())
+			return nil, err
+		}
+		if err := database.CreateIndexes(ctx, client, cfg.Mongo.Database); err != nil {
+			_ = client.Disconnect(context.Background())
+			return nil, fmt.Errorf("cannot create database indexes: %w", err)
+		}
+		c.Store = database.NewMongoStore(client, cfg.Mongo.Database)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+
+	c.Tokens = tokens.NewManager(cfg, c.Store.Users)
+	c.Pricing = pricing.NewCalculator(cfg.Pricing)
+	c.Shipping = shipping.NewCalculator(cfg.Shipping)
+
+	provider, err := payments.New(cfg.Payments)
+	if err != nil {
+		_ = c.Close(context.Background())
+		return nil, err
+	}
+	c.Payments = provider
+
+	return c, nil
+}
+
+// Close releases the database connection, if any.
+func (c *Container) Close(ctx context.Context) error {
+	if c.mongoClient == nil {
+		return nil
+	}
+	return c.mongoClient.Disconnect(ctx)
+}
@@ -4,183 +4,235 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"time"
+	"reflect"
+	"strings"
 
+	"github.com/fredele20/e-commerce-cart/addresses"
+	"github.com/fredele20/e-commerce-cart/database"
 	"github.com/fredele20/e-commerce-cart/models"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func AddAddress() gin.HandlerFunc {
+func addressStatus(err error) int {
+	switch err {
+	case database.ErrAddressNotFound, database.ErrUserNotFound:
+		return http.StatusNotFound
+	case database.ErrAddressLimit:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// bindAddress reads an address from the request body, normalizes it and
+// validates it against the rules of its country.
+func bindAddress(c *gin.Context) (models.Address, bool) {
+	var address models.Address
+	if err := c.BindJSON(&address); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return address, false
+	}
+
+	if validationErr := Validate.Struct(address); validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address", "fields": addressFieldErrors(validationErr)})
+		return address, false
+	}
+
+	addresses.Normalize(&address)
+	if problems := addresses.Validate(address); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address", "fields": problems})
+		return address, false
+	}
+
+	return address, true
+}
+
+// addressFieldErrors reports the struct tag validation failures of an address
+// the way addresses.Validate does, by the JSON name of each field.
+func addressFieldErrors(err error) []addresses.FieldError {
+	failures, ok := err.(validator.ValidationErrors)
+	if !ok {
+		return []addresses.FieldError{{Message: err.Error()}}
+	}
+
+	addressType := reflect.TypeOf(models.Address{})
+	problems := make([]addresses.FieldError, 0, len(failures))
+	for _, failure := range failures {
+		field := failure.Field()
+		if structField, ok := addressType.FieldByName(failure.StructField()); ok {
+			field = strings.Split(structField.Tag.Get("json"), ",")[0]
+		}
+
+		message := "is not valid"
+		switch failure.Tag() {
+		case "max":
+			message = fmt.Sprintf("must be at most %s characters", failure.Param())
+		case "required":
+			message = "is required"
+		}
+		problems = append(problems, addresses.FieldError{Field: field, Message: message})
+	}
+	return problems
+}
+
+// findAddress returns the address with the given id from the user's address
+// book.
+func (app *Application) findAddress(ctx context.Context, userID string, addressID primitive.ObjectID) (*models.Address, error) {
+	addresses, err := app.store.Users.ListAddresses(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, address := range addresses {
+		if address.Address_ID == addressID {
+			return &address, nil
+		}
+	}
+	return nil, database.ErrAddressNotFound
+}
+
+func (app *Application) ListAddresses() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "something failed"})
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		address, err := primitive.ObjectIDFromHex(user_id)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		addresses, err := app.store.Users.ListAddresses(ctx, userID)
 		if err != nil {
-			c.IndentedJSON(500, "internal server error")
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		var addresses models.Address
+		c.IndentedJSON(http.StatusOK, addresses)
+	}
+}
 
-		addresses.Address_ID = primitive.NewObjectID()
-		if err = c.BindJSON(&addresses); err != nil {
-			c.IndentedJSON(http.StatusNotAcceptable, err.Error())
+func (app *Application) GetAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-
-		match_filter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: address}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$address"}}}}
-		group := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$address_id"}, {Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
-
-		pointcursor, err := userCollection.Aggregate(ctx, mongo.Pipeline{match_filter, unwind, group})
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
 		if err != nil {
-			c.IndentedJSON(500, "internal server error")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"})
 			return
 		}
 
-		var addressInfo []bson.M
-		if err = pointcursor.All(ctx, addressInfo); err != nil {
-			panic(err)
-		}
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
 
-		var size int32
-		for _, address_no := range addressInfo {
-			count := address_no["count"]
-			size = count.(int32)
-		}
-		if size < 2 {
-			filter := bson.D{primitive.E{Key: "_id", Value: address}}
-			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-			_, err := userCollection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				fmt.Println(err)
-			}
-		} else {
-			c.IndentedJSON(400, "Not Allowed")
+		address, err := app.findAddress(ctx, userID, addressID)
+		if err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
+			return
 		}
-		defer cancel()
-		ctx.Done()
+
+		c.IndentedJSON(http.StatusOK, address)
 	}
 }
 
-func EditHomeAddress() gin.HandlerFunc {
+func (app *Application) AddAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid"})
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
-		if err != nil {
-			c.IndentedJSON(500, "internal server error")
+		address, ok := bindAddress(c)
+		if !ok {
 			return
 		}
+		address.Address_ID = primitive.NewObjectID()
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
 
-		var editAddress models.Address
-		if err := c.BindJSON(&editAddress); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
+		err = app.store.Users.AddAddress(ctx, userID, address, app.cfg.Addresses.MaxPerUser)
+		if err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house_name", Value: editAddress.House}, {Key: "address.0.street_name", Value: editAddress.Street}, {Key: "address.0.city_name", Value: editAddress.City}, {Key: "address.0.pin_code", Value: editAddress.Pincode}}}}
-		_, err = userCollection.UpdateOne(ctx, filter, update)
+		// read it back, it may have become a default
+		created, err := app.findAddress(ctx, userID, address.Address_ID)
 		if err != nil {
-			c.IndentedJSON(500, "something failed")
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "successfully updated the home address")
+		c.IndentedJSON(http.StatusCreated, created)
 	}
 }
 
-func EditWorkAddress() gin.HandlerFunc {
+func (app *Application) UpdateAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid"})
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
 		if err != nil {
-			c.IndentedJSON(500, "internal server error")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"})
 			return
 		}
 
-		var editAddress models.Address
-		if err := c.BindJSON(&editAddress); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
+		address, ok := bindAddress(c)
+		if !ok {
 			return
 		}
+		address.Address_ID = addressID
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house_name", Value: editAddress.House}, {Key: "address.1.street_name", Value: editAddress.Street}, {Key: "address.1.city_name", Value: editAddress.City}, {Key: "address.1.pin_code", Value: editAddress.Pincode}}}}
 
-		_, err = userCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(500, "something failed")
+		if err := app.store.Users.UpdateAddress(ctx, userID, address); err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		defer cancel()
-		ctx.Done()
-		c.JSON(200, "successfully updated the work address")
+		c.IndentedJSON(http.StatusOK, address)
 	}
 }
 
-func DeleteAddress() gin.HandlerFunc {
+func (app *Application) DeleteAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid search index"})
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		addresses := make([]models.Address, 0)
-		usert_id, err := primitive.ObjectIDFromHex(user_id)
+		addressID, err := primitive.ObjectIDFromHex(c.Param("id"))
 		if err != nil {
-			c.IndentedJSON(500, "Internal server error")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address id"})
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
-		filter := bson.D{primitive.E{Key: "_id", Value: usert_id}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-		_, err = userCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(404, "something failed")
+
+		if err := app.store.Users.DeleteAddress(ctx, userID, addressID); err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "successfully deleted")
+		c.IndentedJSON(http.StatusOK, "successfully deleted the address")
 	}
 }
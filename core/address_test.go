@@ -0,0 +1,124 @@
+package core_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	ID               string `json:"_id"`
+	Label            string `json:"label"`
+	Default_Shipping bool   `json:"default_shipping"`
+	Default_Billing  bool   `json:"default_billing"`
+}
+
+func (s *server) addresses(user account) map[string]address {
+	s.t.Helper()
+	var listed []address
+	s.expect(s.do(http.MethodGet, "/addresses", user.Token, nil), http.StatusOK, &listed)
+	book := make(map[string]address)
+	for _, entry := range listed {
+		book[entry.Label] = entry
+	}
+	return book
+}
+
+func homeAddress(label string) map[string]interface{} {
+	return map[string]interface{}{
+		"label":       label,
+		"country":     "NG",
+		"state":       "Lagos",
+		"street_name": "Allen Avenue",
+		"city_name":   "Ikeja",
+		"pin_code":    "100001",
+	}
+}
+
+func TestAddressBook(t *testing.T) {
+	s := newServer(t)
+	user := s.signup("ada@example.com", "08011111111")
+	other := s.signup("obi@example.com", "08033333333")
+
+	var home address
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, homeAddress("Home")), http.StatusCreated, &home)
+	if !home.Default_Shipping || !home.Default_Billing {
+		t.Errorf("first address = %+v, want it to be both defaults", home)
+	}
+
+	work := homeAddress("Work")
+	work["default_billing"] = true
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, work), http.StatusCreated, nil)
+	book := s.addresses(user)
+	if !book["Home"].Default_Shipping || book["Home"].Default_Billing || book["Work"].Default_Shipping || !book["Work"].Default_Billing {
+		t.Errorf("address book = %+v, want Home to ship and Work to bill", book)
+	}
+
+	work["default_shipping"] = true
+	s.expect(s.do(http.MethodPut, "/addresses/"+book["Work"].ID, user.Token, work), http.StatusOK, nil)
+	book = s.addresses(user)
+	if book["Home"].Default_Shipping || !book["Work"].Default_Shipping || !book["Work"].Default_Billing {
+		t.Errorf("address book = %+v, want Work to be both defaults", book)
+	}
+
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, homeAddress(strings.Repeat("x", 51))), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodGet, "/addresses/"+home.ID, other.Token, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodPut, "/addresses/"+home.ID, other.Token, homeAddress("Mine")), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodDelete, "/addresses/"+home.ID, other.Token, nil), http.StatusNotFound, nil)
+
+	s.expect(s.do(http.MethodDelete, "/addresses/"+home.ID, user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/addresses/"+home.ID, user.Token, nil), http.StatusNotFound, nil)
+	if book = s.addresses(user); len(book) != 1 {
+		t.Errorf("address book = %+v, want only Work", book)
+	}
+}
+
+func TestAddressBookLimit(t *testing.T) {
+	s := newServer(t)
+	user := s.signup("ada@example.com", "08011111111")
+
+	for _, label := range []string{"a", "b", "c", "d", "e"} {
+		s.expect(s.do(http.MethodPost, "/addresses", user.Token, homeAddress(label)), http.StatusCreated, nil)
+	}
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, homeAddress("f")), http.StatusConflict, nil)
+}
+
+func TestAddressesAreNormalizedAndValidated(t *testing.T) {
+	s := newServer(t)
+	user := s.signup("ada@example.com", "08011111111")
+
+	var problems struct {
+		Fields []struct {
+			Field string `json:"field"`
+		} `json:"fields"`
+	}
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, map[string]string{
+		"country":     "GB",
+		"street_name": "Downing St",
+		"city_name":   "London",
+		"pin_code":    "SW1A",
+	}), http.StatusBadRequest, &problems)
+	if len(problems.Fields) != 1 || problems.Fields[0].Field != "pin_code" {
+		t.Errorf("problems = %+v, want the pin_code", problems)
+	}
+
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, homeAddress(strings.Repeat("x", 51))), http.StatusBadRequest, &problems)
+	if len(problems.Fields) != 1 || problems.Fields[0].Field != "label" {
+		t.Errorf("problems = %+v, want the label", problems)
+	}
+
+	var created struct {
+		Country string `json:"country"`
+		City    string `json:"city_name"`
+		Pincode string `json:"pin_code"`
+	}
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, map[string]string{
+		"country":     " gb",
+		"street_name": "downing   st",
+		"city_name":   "LONDON",
+		"pin_code":    "sw1a1aa",
+	}), http.StatusCreated, &created)
+	if created.Country != "GB" || created.City != "London" || created.Pincode != "SW1A 1AA" {
+		t.Errorf("created = %+v, want it normalized", created)
+	}
+}
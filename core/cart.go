@@ -5,42 +5,131 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"time"
+	"strconv"
 
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/container"
 	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/middleware"
 	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/fredele20/e-commerce-cart/payments"
+	"github.com/fredele20/e-commerce-cart/pricing"
+	"github.com/fredele20/e-commerce-cart/shipping"
+	"github.com/fredele20/e-commerce-cart/tokens"
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Application struct {
-	prodCollection *mongo.Collection
-	userCollection *mongo.Collection
+	store    *database.Store
+	cfg      *config.Config
+	tokens   *tokens.Manager
+	pricing  *pricing.Calculator
+	shipping *shipping.Calculator
+	payments payments.Provider
 }
 
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
+const ImpersonationHeader = "X-Act-As-User"
+
+var (
+	ErrNoAuthenticatedUser   = errors.New("no authenticated user")
+	ErrImpersonationRequired = errors.New("admins must name the customer they act for in the " + ImpersonationHeader + " header")
+	ErrImpersonationDenied   = errors.New("only admins can act on behalf of another user")
+)
+
+// actingUserID resolves the user a cart or order request operates on. It is
+// always the authenticated user, except for admins, who must explicitly name
+// the customer they are supporting through the ImpersonationHeader.
+func actingUserID(c *gin.Context) (string, int, error) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		return "", http.StatusUnauthorized, ErrNoAuthenticatedUser
+	}
+
+	target := c.GetHeader(ImpersonationHeader)
+	isAdmin := middleware.HasRole(c, models.RoleAdmin)
+
+	switch {
+	case target != "" && !isAdmin:
+		return "", http.StatusForbidden, ErrImpersonationDenied
+	case target != "":
+		log.Printf("admin %s acting as user %s on %s %s", uid, target, c.Request.Method, c.FullPath())
+		return target, 0, nil
+	case isAdmin:
+		return "", http.StatusBadRequest, ErrImpersonationRequired
+	}
+
+	return uid, 0, nil
+}
+
+func NewApplication(c *container.Container) *Application {
 	return &Application{
-		prodCollection: prodCollection,
-		userCollection: userCollection,
+		store:    c.Store,
+		cfg:      c.Config,
+		tokens:   c.Tokens,
+		pricing:  c.Pricing,
+		shipping: c.Shipping,
+		payments: c.Payments,
 	}
 }
 
+func cartStatus(err error) int {
+	switch err {
+	case database.ErrProductNotFound, database.ErrCartItemNotFound, database.ErrUserNotFound:
+		return http.StatusNotFound
+	case database.ErrQuantityLimit:
+		return http.StatusBadRequest
+	case database.ErrInsufficientStock:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// quantityParam reads the optional quantity query parameter, which must be a
+// positive number when given.
+func quantityParam(c *gin.Context) (int, error) {
+	value := c.DefaultQuery("quantity", "1")
+	quantity, err := strconv.Atoi(value)
+	if err != nil || quantity < 1 {
+		return 0, errors.New("quantity must be a positive number")
+	}
+	return quantity, nil
+}
+
+// maxQuantity returns the most units of a product a single cart line may
+// hold.
+func (app *Application) maxQuantity(product *models.Product) int {
+	maxQuantity := app.cfg.Cart.MaxQuantityPerLine
+	if product.Max_Quantity > 0 && product.Max_Quantity < maxQuantity {
+		maxQuantity = product.Max_Quantity
+	}
+	return maxQuantity
+}
 
 func (app *Application) AddToCart() gin.HandlerFunc {
+	return app.addToCart(func(ctx *gin.Context) string { return ctx.Query("id") })
+}
+
+// IncrementCartItem adds to the cart line of the product named in the path.
+func (app *Application) IncrementCartItem() gin.HandlerFunc {
+	return app.addToCart(func(ctx *gin.Context) string { return ctx.Param("id") })
+}
+
+// addToCart adds units of the product whose id productParam reads from the
+// request.
+func (app *Application) addToCart(productParam func(*gin.Context) string) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		productQueryID := ctx.Query("id")
+		productQueryID := productParam(ctx)
 		if productQueryID == "" {
 			log.Println("product id is empty")
 			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("product id is empty"))
 			return
 		}
 
-		userQueryID := ctx.Query("userID")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+		userQueryID, status, err := actingUserID(ctx)
+		if err != nil {
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			ctx.Abort()
 			return
 		}
 
@@ -51,12 +140,47 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			return
 		}
 
-		var prodCtx, cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+		quantity, err := quantityParam(ctx)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var prodCtx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		err = database.AddProductToCart(prodCtx, app.prodCollection, app.userCollection, productID, userQueryID)
+		product, err := app.store.Products.GetProduct(prodCtx, productID)
 		if err != nil {
-			ctx.IndentedJSON(http.StatusInternalServerError, err)
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		current, err := app.lineQuantity(prodCtx, userQueryID, productID)
+		if err != nil {
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		maxQuantity := app.maxQuantity(product)
+		if current+quantity > maxQuantity {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": database.ErrQuantityLimit.Error()})
+			return
+		}
+
+		err = app.holdStock(prodCtx, userQueryID, product, current+quantity)
+		if err == database.ErrInsufficientStock {
+			stockUnavailable(ctx, productID)
+			return
+		}
+		if err != nil {
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		err = app.store.Carts.AddProductToCart(prodCtx, userQueryID, models.NewProductUser(*product), quantity, maxQuantity)
+		if err != nil {
+			app.syncHold(prodCtx, userQueryID, productID)
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
@@ -64,6 +188,116 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 	}
 }
 
+func (app *Application) DecrementCartItem() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		userQueryID, status, err := actingUserID(ctx)
+		if err != nil {
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			ctx.Abort()
+			return
+		}
+
+		quantity, err := quantityParam(ctx)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var prodCtx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		err = app.store.Carts.DecrementCartItem(prodCtx, productID, userQueryID, quantity)
+		if err != nil {
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		app.syncHold(prodCtx, userQueryID, productID)
+
+		ctx.IndentedJSON(200, "successfully updated the cart")
+	}
+}
+
+func (app *Application) SetCartItemQuantity() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		productID, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid product id"})
+			return
+		}
+
+		userQueryID, status, err := actingUserID(ctx)
+		if err != nil {
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			ctx.Abort()
+			return
+		}
+
+		var body struct {
+			Quantity *int `json:"quantity" validate:"required,min=0"`
+		}
+		if err := ctx.BindJSON(&body); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := Validate.Struct(body); validationErr != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var prodCtx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		if *body.Quantity == 0 {
+			err = app.store.Carts.RemoveCartItem(prodCtx, productID, userQueryID)
+			if err != nil {
+				ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			app.syncHold(prodCtx, userQueryID, productID)
+			ctx.IndentedJSON(200, "successfully updated the cart")
+			return
+		}
+
+		product, err := app.store.Products.GetProduct(prodCtx, productID)
+		if err != nil {
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		maxQuantity := app.maxQuantity(product)
+		if *body.Quantity > maxQuantity {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": database.ErrQuantityLimit.Error()})
+			return
+		}
+
+		err = app.holdStock(prodCtx, userQueryID, product, *body.Quantity)
+		if err == database.ErrInsufficientStock {
+			stockUnavailable(ctx, productID)
+			return
+		}
+		if err != nil {
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		err = app.store.Carts.SetCartItemQuantity(prodCtx, userQueryID, models.NewProductUser(*product), *body.Quantity, maxQuantity)
+		if err != nil {
+			app.syncHold(prodCtx, userQueryID, productID)
+			ctx.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx.IndentedJSON(200, "successfully updated the cart")
+	}
+}
+
 func (app *Application) RemoveItem() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		productQueryID := ctx.Query("id")
@@ -73,10 +307,10 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
-		userQueryID := ctx.Query("userId")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			_ = ctx.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+		userQueryID, status, err := actingUserID(ctx)
+		if err != nil {
+			ctx.JSON(status, gin.H{"error": err.Error()})
+			ctx.Abort()
 			return
 		}
 
@@ -87,92 +321,152 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
-		var prodCtx, cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+		var prodCtx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		err = database.RemoveCartItem(prodCtx, app.prodCollection, app.userCollection, productID, userQueryID)
+		err = app.store.Carts.RemoveCartItem(prodCtx, productID, userQueryID)
 		if err != nil {
 			ctx.IndentedJSON(http.StatusInternalServerError, err)
 			return
 		}
+		app.syncHold(prodCtx, userQueryID, productID)
 
 		ctx.IndentedJSON(200, "item successfully removed from cart")
 	}
 }
 
-
-func GetItemFromCart() gin.HandlerFunc {
+func (app *Application) GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user_id := c.Query("id")
-		if user_id == "" {
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "invalid id"})
+		user_id, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		usert_id, _ := primitive.ObjectIDFromHex(user_id)
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100 * time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		var filledcart models.User
-		err := userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: usert_id}}).Decode(filledcart)
+		filledcart, err := app.store.Carts.GetCart(ctx, user_id)
 		if err != nil {
 			log.Println(err)
-			c.IndentedJSON(404, "not found")
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		filter_match := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: usert_id}}}}
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$usercart"}}}}
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, 
-		{Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
-
-		pointcursor, err := userCollection.Aggregate(ctx, mongo.Pipeline{filter_match, unwind, grouping})
-		if err != nil {
-			log.Panicln(err)
+		items, missing, err := app.currentItems(ctx, filledcart.Items)
+		if err == database.ErrProductNotFound {
+			productUnavailable(c, missing.Product_ID)
+			return
 		}
-
-		var listing []bson.M
-		if err = pointcursor.All(ctx, &listing); err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledcart.UserCart)
+		// tax depends on where the cart ships, so price it for the address
+		// checkout would use, when there is one
+		address, status, err := app.shippingAddress(ctx, c, user_id)
+		if err != nil && err != ErrShippingAddressRequired {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
 		}
 
-		ctx.Done()
+		c.IndentedJSON(200, app.pricing.Quote(items, address))
 	}
 }
 
-
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userQueryID := c.Query("id")
-		if userQueryID == "" {
-			log.Panic("user id is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("userID is empty"))
+		userQueryID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		method, err := app.paymentMethod(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100 * time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryID)
+		cart, err := app.store.Carts.GetCart(ctx, userQueryID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(cart.Items) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrCartEmpty.Error()})
+			return
+		}
+
+		items, missing, err := app.currentItems(ctx, cart.Items)
+		if err == database.ErrProductNotFound {
+			productUnavailable(c, missing.Product_ID)
+			return
+		}
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		address, status, err := app.shippingAddress(ctx, c, userQueryID)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.IndentedJSON(200, "successfully placed the order")
+		delivery, err := app.shipping.Choose(*address, items, shippingOption(c))
+		if err != nil {
+			c.JSON(shippingStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		order := newOrder(items, app.pricing.Quote(items, address), address, delivery)
+		order.User_ID = userQueryID
+		order.Idempotency_Key = c.GetHeader(IdempotencyKeyHeader)
+
+		if err = app.authorizePayment(ctx, &order, method, c.Query("payment_token")); err != nil {
+			c.JSON(paymentStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		// the stock is taken together with placing the order, so an order
+		// that cannot be placed leaves the stock and the user's holds as
+		// they were and only the payment has to be released
+		failed, err := app.store.Orders.BuyItemFromCart(ctx, userQueryID, order, cart.Version)
+		if err != nil {
+			app.voidPayment(ctx, &order)
+		}
+		switch err {
+		case nil:
+		case database.ErrInsufficientStock:
+			stockUnavailable(c, failed.Product_ID)
+			return
+		case database.ErrProductNotFound:
+			productUnavailable(c, failed.Product_ID)
+			return
+		case database.ErrCartEmpty:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		case database.ErrCartChanged:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(200, order)
 	}
 }
 
-func (app *Application) InstantBuy() gin.HandlerFunc{
+func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		productQueryID := c.Query("id")
 		if productQueryID == "" {
@@ -181,10 +475,10 @@ func (app *Application) InstantBuy() gin.HandlerFunc{
 			return
 		}
 
-		userQueryID := c.Query("userId")
-		if userQueryID == "" {
-			log.Println("user id is empty")
-			c.AbortWithError(http.StatusBadRequest, errors.New("user id is empty"))
+		userQueryID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
 			return
 		}
 
@@ -195,16 +489,77 @@ func (app *Application) InstantBuy() gin.HandlerFunc{
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 5 * time.Second)
+		quantity, err := quantityParam(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		method, err := app.paymentMethod(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		err = database.InstantBuyer(ctx, app.prodCollection, app.userCollection, productID, userQueryID)
+		product, err := app.store.Products.GetProduct(ctx, productID)
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if quantity > app.maxQuantity(product) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrQuantityLimit.Error()})
+			return
+		}
+
+		address, status, err := app.shippingAddress(ctx, c, userQueryID)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		item := models.NewProductUser(*product)
+		item.Quantity = quantity
+		items := []models.ProductUser{item}
+
+		delivery, err := app.shipping.Choose(*address, items, shippingOption(c))
+		if err != nil {
+			c.JSON(shippingStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		_, err = app.commitStock(ctx, userQueryID, items)
+		if err == database.ErrInsufficientStock {
+			stockUnavailable(c, productID)
+			return
+		}
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		order := newOrder(items, app.pricing.Quote(items, address), address, delivery)
+		order.User_ID = userQueryID
+		order.Idempotency_Key = c.GetHeader(IdempotencyKeyHeader)
+
+		if err = app.authorizePayment(ctx, &order, method, c.Query("payment_token")); err != nil {
+			app.restoreStock(ctx, items)
+			c.JSON(paymentStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		err = app.store.Orders.InstantBuyer(ctx, userQueryID, order)
 		if err != nil {
+			app.voidPayment(ctx, &order)
+			app.restoreStock(ctx, items)
 			log.Println(err)
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.IndentedJSON(200, "successfully placed the order")
+		c.IndentedJSON(200, order)
 	}
-}
\ No newline at end of file
+}
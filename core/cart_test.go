@@ -0,0 +1,226 @@
+package core_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCartQuantities(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+	admin := s.signup(adminEmail, "08022222222")
+	lamp := s.addProduct(admin, 1500, 10)
+	items := "/cart/items/" + lamp.ID
+
+	steps := []struct {
+		name     string
+		method   string
+		path     string
+		body     interface{}
+		status   int
+		quantity int
+	}{
+		{"increment adds a new line", http.MethodPost, items + "/increment?quantity=2", nil, http.StatusOK, 2},
+		{"increment adds to the line", http.MethodPost, items + "/increment", nil, http.StatusOK, 3},
+		{"increment cannot pass the limit", http.MethodPost, items + "/increment?quantity=8", nil, http.StatusBadRequest, 3},
+		{"increment needs a positive quantity", http.MethodPost, items + "/increment?quantity=0", nil, http.StatusBadRequest, 3},
+		{"decrement takes units off", http.MethodPost, items + "/decrement", nil, http.StatusOK, 2},
+		{"set replaces the quantity", http.MethodPut, items, map[string]int{"quantity": 7}, http.StatusOK, 7},
+		{"set cannot pass the limit", http.MethodPut, items, map[string]int{"quantity": 11}, http.StatusBadRequest, 7},
+		{"decrement past zero drops the line", http.MethodPost, items + "/decrement?quantity=9", nil, http.StatusOK, 0},
+		{"decrement needs the line", http.MethodPost, items + "/decrement", nil, http.StatusNotFound, 0},
+		{"set recreates the line", http.MethodPut, items, map[string]int{"quantity": 4}, http.StatusOK, 4},
+		{"set to zero drops the line", http.MethodPut, items, map[string]int{"quantity": 0}, http.StatusOK, 0},
+	}
+
+	for _, step := range steps {
+		s.expect(s.do(step.method, step.path, user.Token, step.body), step.status, nil)
+		if got := s.cart(user)[lamp.ID]; got != step.quantity {
+			t.Fatalf("%s: quantity = %d, want %d", step.name, got, step.quantity)
+		}
+	}
+}
+
+func TestIncrementOnlyReadsThePath(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+	admin := s.signup(adminEmail, "08022222222")
+	lamp := s.addProduct(admin, 1500, 10)
+	desk := s.addProduct(admin, 9000, 10)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment?id="+desk.ID, user.Token, nil), http.StatusOK, nil)
+	if cart := s.cart(user); cart[lamp.ID] != 1 || cart[desk.ID] != 0 {
+		t.Errorf("cart = %v, want one %s", cart, lamp.ID)
+	}
+}
+
+type cartView struct {
+	Lines []struct {
+		Product_ID string `json:"product_id"`
+		Quantity   int    `json:"quantity"`
+		Line_Total int    `json:"line_total"`
+	} `json:"lines"`
+	Item_Count int `json:"item_count"`
+	Subtotal   int `json:"subtotal"`
+	Total      int `json:"total"`
+}
+
+func TestGetCart(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+	admin := s.signup(adminEmail, "08022222222")
+	lamp := s.addProduct(admin, 1500, 10)
+
+	var empty cartView
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &empty)
+	if len(empty.Lines) != 0 || empty.Item_Count != 0 || empty.Total != 0 {
+		t.Errorf("empty cart = %+v", empty)
+	}
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment?quantity=2", user.Token, nil), http.StatusOK, nil)
+
+	var filled cartView
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &filled)
+	if len(filled.Lines) != 1 || filled.Lines[0].Product_ID != lamp.ID || filled.Lines[0].Quantity != 2 || filled.Lines[0].Line_Total != 3000 {
+		t.Fatalf("cart lines = %+v", filled.Lines)
+	}
+	if filled.Item_Count != 2 || filled.Subtotal != 3000 {
+		t.Errorf("cart = %+v, want 2 items worth 3000", filled)
+	}
+}
+
+func TestCheckoutTakesStockAndEmptiesTheCart(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 3)
+
+	s.expect(s.do(http.MethodGet, "/addtocart?id="+lamp.ID+"&quantity=2", user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/cartcheckout", user.Token, nil), http.StatusOK, nil)
+
+	var cart cartView
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &cart)
+	if len(cart.Lines) != 0 || cart.Total != 0 {
+		t.Errorf("cart after checkout = %+v, want it empty", cart)
+	}
+
+	if stock := s.stock(admin, lamp.ID); stock != 1 {
+		t.Errorf("stock = %d, want 1", stock)
+	}
+}
+
+func TestCheckoutRefusesMoreThanTheStock(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 1)
+
+	var refused struct {
+		Code string `json:"code"`
+	}
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&quantity=2", user.Token, nil), http.StatusConflict, &refused)
+	if refused.Code != "insufficient_stock" {
+		t.Errorf("code = %q, want insufficient_stock", refused.Code)
+	}
+
+	s.expect(s.do(http.MethodGet, "/addtocart?id="+lamp.ID+"&quantity=2", user.Token, nil), http.StatusConflict, &refused)
+	if refused.Code != "insufficient_stock" {
+		t.Errorf("code = %q, want insufficient_stock", refused.Code)
+	}
+
+	if stock := s.stock(admin, lamp.ID); stock != 1 {
+		t.Errorf("stock = %d, want 1", stock)
+	}
+}
+
+func TestCheckoutReplaysARepeatedIdempotencyKey(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 3)
+
+	var first, second order
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, user.Token, nil, "Idempotency-Key", "order-1"), http.StatusOK, &first)
+
+	replay := s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, user.Token, nil, "Idempotency-Key", "order-1")
+	s.expect(replay, http.StatusOK, &second)
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("the repeated checkout was not marked as replayed")
+	}
+	if first.ID == "" || second.ID != first.ID {
+		t.Errorf("replayed order %q, want %q", second.ID, first.ID)
+	}
+
+	if stock := s.stock(admin, lamp.ID); stock != 2 {
+		t.Errorf("stock = %d, want 2", stock)
+	}
+
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&quantity=2", user.Token, nil, "Idempotency-Key", "order-1"), http.StatusUnprocessableEntity, nil)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, user.Token, nil, "Idempotency-Key", "order-2"), http.StatusOK, nil)
+
+	if stock := s.stock(admin, lamp.ID); stock != 1 {
+		t.Errorf("stock = %d, want 1", stock)
+	}
+}
+
+func TestCheckoutNeedsAShippingAddress(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.signup("ada@example.com", "08011111111")
+	lamp := s.addProduct(admin, 1500, 3)
+
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, user.Token, nil), http.StatusBadRequest, nil)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&address_id="+lamp.ID, user.Token, nil), http.StatusNotFound, nil)
+	if stock := s.stock(admin, lamp.ID); stock != 3 {
+		t.Errorf("stock = %d, want 3", stock)
+	}
+
+	office := map[string]string{"label": "Office", "country": "NG", "state": "Lagos", "street_name": "Broad Street", "city_name": "Lagos"}
+	var created address
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, office), http.StatusCreated, &created)
+
+	placed := s.buyWith(user, "/instantbuy?id="+lamp.ID+"&address_id="+created.ID)
+
+	// later edits to the address book leave the order as it was
+	office["street_name"] = "Marina"
+	s.expect(s.do(http.MethodPut, "/addresses/"+created.ID, user.Token, office), http.StatusOK, nil)
+
+	var fetched struct {
+		Address struct {
+			ID     string `json:"_id"`
+			Street string `json:"street_name"`
+		} `json:"address"`
+	}
+	s.expect(s.do(http.MethodGet, "/orders/"+placed.ID, user.Token, nil), http.StatusOK, &fetched)
+	if fetched.Address.ID != created.ID || fetched.Address.Street != "Broad Street" {
+		t.Errorf("order ships to %+v, want the office as it was at checkout", fetched.Address)
+	}
+}
+
+func TestCheckoutLeavesTheCartWhenTheStockRunsOut(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	other := s.signup("bola@example.com", "08033333333")
+	lamp := s.addProduct(admin, 1500, 1)
+
+	s.expect(s.do(http.MethodGet, "/addtocart?id="+lamp.ID, user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPost, "/addresses", other.Token, homeAddress("Home")), http.StatusCreated, nil)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, other.Token, nil), http.StatusOK, nil)
+
+	var refused struct {
+		Code       string `json:"code"`
+		Product_ID string `json:"product_id"`
+	}
+	s.expect(s.do(http.MethodGet, "/cartcheckout", user.Token, nil), http.StatusConflict, &refused)
+	if refused.Code != "insufficient_stock" || refused.Product_ID != lamp.ID {
+		t.Errorf("checkout refused with %+v, want the lamp out of stock", refused)
+	}
+
+	if quantities := s.cart(user); quantities[lamp.ID] != 1 {
+		t.Errorf("cart = %v, want the lamp kept", quantities)
+	}
+	if stock := s.stock(admin, lamp.ID); stock != 0 {
+		t.Errorf("stock = %d, want 0", stock)
+	}
+}
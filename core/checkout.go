@@ -0,0 +1,172 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/fredele20/e-commerce-cart/pricing"
+	"github.com/fredele20/e-commerce-cart/shipping"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrShippingAddressRequired = errors.New("choose a shipping address with address_id or set a default one")
+
+// shippingAddress picks the address an order ships to: the one named by the
+// address_id query parameter, or else the user's default shipping address.
+// The result is a copy, so the order keeps it as it was at checkout.
+func (app *Application) shippingAddress(ctx context.Context, c *gin.Context, userID string) (*models.Address, int, error) {
+	var addressID primitive.ObjectID
+	if value := c.Query("address_id"); value != "" {
+		id, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("invalid address id")
+		}
+		addressID = id
+	}
+
+	addresses, err := app.store.Users.ListAddresses(ctx, userID)
+	if err != nil {
+		return nil, addressStatus(err), err
+	}
+
+	for _, address := range addresses {
+		if address.Address_ID == addressID || (addressID.IsZero() && address.Default_Shipping) {
+			address.Default_Shipping = false
+			address.Default_Billing = false
+			return &address, http.StatusOK, nil
+		}
+	}
+
+	if !addressID.IsZero() {
+		return nil, http.StatusNotFound, database.ErrAddressNotFound
+	}
+	return nil, http.StatusBadRequest, ErrShippingAddressRequired
+}
+
+func shippingStatus(err error) int {
+	switch err {
+	case shipping.ErrNoZone, shipping.ErrTooHeavy:
+		return http.StatusUnprocessableEntity
+	case shipping.ErrOptionUnavailable:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// shippingOption is the delivery option asked for with the shipping query
+// parameter, standard unless told otherwise.
+func shippingOption(c *gin.Context) string {
+	return c.DefaultQuery("shipping", models.ShippingStandard)
+}
+
+// newOrder builds the order for the given items from their price quote, so
+// checkout charges exactly what the cart view showed, plus delivery to
+// address.
+// productUnavailable answers a request for a cart holding a product that is
+// no longer sold.
+func productUnavailable(c *gin.Context, productID primitive.ObjectID) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":      database.ErrProductNotFound.Error(),
+		"code":       "product_unavailable",
+		"product_id": productID,
+	})
+}
+
+// currentItems refreshes cart lines from their products, so quotes charge,
+// tax and ship what the products cost, are filed under and weigh now rather
+// than when they were added to the cart. On failure the line whose product is gone is returned alongside
+// the error.
+func (app *Application) currentItems(ctx context.Context, items []models.ProductUser) ([]models.ProductUser, *models.ProductUser, error) {
+	current := make([]models.ProductUser, len(items))
+	for i, item := range items {
+		product, err := app.store.Products.GetProduct(ctx, item.Product_ID)
+		if err == database.ErrProductNotFound {
+			return nil, &item, err
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		fresh := models.NewProductUser(*product)
+		item.Price = fresh.Price
+		item.Weight = fresh.Weight
+		item.Category = fresh.Category
+		current[i] = item
+	}
+	return current, nil, nil
+}
+
+func newOrder(items []models.ProductUser, quote pricing.Quote, address *models.Address, delivery *models.Shipping) models.Order {
+	var order models.Order
+
+	order.Order_ID = primitive.NewObjectID()
+	order.Order_At = time.Now()
+	order.Status = models.OrderPending
+	order.Status_History = []models.StatusChange{{Status: models.OrderPending, At: order.Order_At}}
+	order.Order_Cart = make([]models.ProductUser, len(items))
+	for i, item := range items {
+		tax := quote.Lines[i].Tax
+		item.Tax = &tax
+		order.Order_Cart[i] = item
+	}
+	order.Address = address
+	order.Shipping = delivery
+	order.Subtotal = quote.Subtotal
+	order.Discount = &quote.Discount
+	order.Tax = quote.Tax
+	order.Tax_Mode = quote.Tax_Mode
+	order.Price = quote.Total + delivery.Cost
+	order.Payment_Method = models.Payment{Method: models.PaymentCOD, COD: true}
+
+	return order
+}
+
+// ShippingOptions lists the ways the cart can be delivered to the address
+// checkout would use, with their prices.
+func (app *Application) ShippingOptions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		cart, err := app.store.Carts.GetCart(ctx, userID)
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		items, missing, err := app.currentItems(ctx, cart.Items)
+		if err == database.ErrProductNotFound {
+			productUnavailable(c, missing.Product_ID)
+			return
+		}
+		if err != nil {
+			c.JSON(cartStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		address, status, err := app.shippingAddress(ctx, c, userID)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		options, err := app.shipping.Quote(*address, items)
+		if err != nil {
+			c.JSON(shippingStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, options)
+	}
+}
@@ -0,0 +1,235 @@
+package core_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+func withShippingZones(cfg *config.Config) {
+	cfg.Shipping.Zones = []config.ShippingZone{{
+		Name:      "domestic",
+		Countries: []string{"NG"},
+		Options: []config.ShippingOption{
+			{Name: models.ShippingStandard, Days: 5, Rates: []config.ShippingRate{{MaxWeight: 1000, Price: 500}, {Price: 2000}}},
+			{Name: models.ShippingExpress, Days: 1, Rates: []config.ShippingRate{{MaxWeight: 5000, Price: 3000}}},
+		},
+	}}
+}
+
+func (s *server) addParcel(admin account, price, weight int) product {
+	s.t.Helper()
+	var created product
+	s.expect(s.do(http.MethodPost, "/admin/addproduct", admin.Token, map[string]interface{}{
+		"product_name": "Desk lamp",
+		"price":        price,
+		"image":        "https://example.com/lamp.png",
+		"stock":        10,
+		"weight":       weight,
+	}), http.StatusCreated, &created)
+	return created
+}
+
+func TestShippingIsChargedByZoneAndWeight(t *testing.T) {
+	s := newServer(t, withShippingZones)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addParcel(admin, 1500, 400)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment?quantity=3", user.Token, nil), http.StatusOK, nil)
+
+	var options struct {
+		Zone    string `json:"zone"`
+		Weight  int    `json:"weight"`
+		Options []struct {
+			Name  string `json:"name"`
+			Price int    `json:"price"`
+		} `json:"options"`
+	}
+	s.expect(s.do(http.MethodGet, "/shipping/options", user.Token, nil), http.StatusOK, &options)
+	if options.Zone != "domestic" || options.Weight != 1200 || len(options.Options) != 2 || options.Options[0].Price != 2000 || options.Options[1].Price != 3000 {
+		t.Errorf("shipping options = %+v, want standard for 2000 and express for 3000", options)
+	}
+
+	ordered := s.buyWith(user, "/cartcheckout?shipping=express")
+
+	var placed struct {
+		Total    int             `json:"total_price"`
+		Shipping models.Shipping `json:"shipping"`
+	}
+	s.expect(s.do(http.MethodGet, "/orders/"+ordered.ID, user.Token, nil), http.StatusOK, &placed)
+	want := models.Shipping{Option: models.ShippingExpress, Zone: "domestic", Weight: 1200, Days: 1, Cost: 3000}
+	if placed.Shipping != want || placed.Total != 4500+3000 {
+		t.Errorf("order ships %+v for a total of %d, want %+v and 7500", placed.Shipping, placed.Total, want)
+	}
+}
+
+func TestShippingRefusesWhatItCannotCarry(t *testing.T) {
+	s := newServer(t, withShippingZones)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addParcel(admin, 1500, 6000)
+
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&shipping=express", user.Token, nil), http.StatusUnprocessableEntity, nil)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&shipping=drone", user.Token, nil), http.StatusBadRequest, nil)
+
+	abroad := map[string]string{"label": "Abroad", "country": "ZA", "street_name": "Long St", "city_name": "Cape Town"}
+	var created address
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, abroad), http.StatusCreated, &created)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&address_id="+created.ID, user.Token, nil), http.StatusUnprocessableEntity, nil)
+
+	if stock := s.stock(admin, lamp.ID); stock != 10 {
+		t.Errorf("stock = %d, want 10", stock)
+	}
+}
+
+func TestOrdersAreTaxedWhereTheyShip(t *testing.T) {
+	s := newServer(t, func(cfg *config.Config) {
+		cfg.Pricing.TaxRate = 10
+		cfg.Pricing.TaxRegions = []config.TaxRegion{{Country: "NG", Rate: 7.5}}
+	})
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 2000, 5)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment", user.Token, nil), http.StatusOK, nil)
+
+	var cart struct {
+		Lines []struct {
+			Tax models.LineTax `json:"tax"`
+		} `json:"lines"`
+		Tax   int `json:"tax"`
+		Total int `json:"total"`
+	}
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &cart)
+	want := models.LineTax{Region: "NG", Rate: 7.5, Taxable: 2000, Amount: 150}
+	if len(cart.Lines) != 1 || cart.Lines[0].Tax != want || cart.Tax != 150 || cart.Total != 2150 {
+		t.Errorf("cart = %+v, want %+v on a total of 2150", cart, want)
+	}
+
+	placed := s.buyWith(user, "/cartcheckout")
+	if placed.Total != 2150 {
+		t.Errorf("order total = %d, want 2150", placed.Total)
+	}
+}
+
+func TestCartsArePricedAtCurrentPrices(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 10)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment?quantity=2", user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPut, "/admin/products/"+lamp.ID, admin.Token, map[string]interface{}{
+		"product_name": "Desk lamp",
+		"price":        2000,
+		"image":        "https://example.com/lamp.png",
+		"stock":        10,
+	}), http.StatusOK, nil)
+
+	var cart cartView
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &cart)
+	if cart.Subtotal != 4000 {
+		t.Errorf("cart subtotal = %d, want 4000 at the new price", cart.Subtotal)
+	}
+
+	placed := s.buyWith(user, "/cartcheckout")
+	if placed.Total != 4000 {
+		t.Errorf("order total = %d, want 4000 at the new price", placed.Total)
+	}
+}
+
+func TestCartsWithRemovedProductsCannotCheckOut(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 10)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment", user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodDelete, "/admin/products/"+lamp.ID, admin.Token, nil), http.StatusOK, nil)
+
+	for _, path := range []string{"/cart", "/shipping/options", "/cartcheckout"} {
+		var refused struct {
+			Code       string `json:"code"`
+			Product_ID string `json:"product_id"`
+		}
+		s.expect(s.do(http.MethodGet, path, user.Token, nil), http.StatusConflict, &refused)
+		if refused.Code != "product_unavailable" || refused.Product_ID != lamp.ID {
+			t.Errorf("%s refused with %+v, want the removed product", path, refused)
+		}
+	}
+
+	s.expect(s.do(http.MethodPut, "/cart/items/"+lamp.ID, user.Token, map[string]int{"quantity": 0}), http.StatusOK, nil)
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, nil)
+}
+
+func TestCartsAreShippedAtCurrentWeights(t *testing.T) {
+	s := newServer(t, withShippingZones)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addParcel(admin, 1500, 200)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment?quantity=3", user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPut, "/admin/products/"+lamp.ID, admin.Token, map[string]interface{}{
+		"product_name": "Desk lamp",
+		"price":        1500,
+		"image":        "https://example.com/lamp.png",
+		"stock":        10,
+		"weight":       400,
+	}), http.StatusOK, nil)
+
+	var options struct {
+		Weight  int `json:"weight"`
+		Options []struct {
+			Price int `json:"price"`
+		} `json:"options"`
+	}
+	s.expect(s.do(http.MethodGet, "/shipping/options", user.Token, nil), http.StatusOK, &options)
+	if options.Weight != 1200 || len(options.Options) == 0 || options.Options[0].Price != 2000 {
+		t.Errorf("shipping options = %+v, want 1200g from 2000", options)
+	}
+
+	ordered := s.buyWith(user, "/cartcheckout")
+	var placed struct {
+		Shipping models.Shipping `json:"shipping"`
+	}
+	s.expect(s.do(http.MethodGet, "/orders/"+ordered.ID, user.Token, nil), http.StatusOK, &placed)
+	if placed.Shipping.Weight != 1200 || placed.Shipping.Cost != 2000 {
+		t.Errorf("order ships %+v, want 1200g for 2000", placed.Shipping)
+	}
+}
+
+func TestCartsAreTaxedAtCurrentCategories(t *testing.T) {
+	s := newServer(t, func(cfg *config.Config) {
+		cfg.Pricing.TaxRate = 10
+		cfg.Pricing.TaxCategories = map[string]float64{"books": 5}
+	})
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 2000, 5)
+
+	s.expect(s.do(http.MethodPost, "/cart/items/"+lamp.ID+"/increment", user.Token, nil), http.StatusOK, nil)
+	s.expect(s.do(http.MethodPut, "/admin/products/"+lamp.ID, admin.Token, map[string]interface{}{
+		"product_name": "Lamp manual",
+		"price":        2000,
+		"image":        "https://example.com/lamp.png",
+		"stock":        5,
+		"category":     "books",
+	}), http.StatusOK, nil)
+
+	var cart struct {
+		Tax   int `json:"tax"`
+		Total int `json:"total"`
+	}
+	s.expect(s.do(http.MethodGet, "/cart", user.Token, nil), http.StatusOK, &cart)
+	if cart.Tax != 100 || cart.Total != 2100 {
+		t.Errorf("cart = %+v, want the books rate of 100 on a total of 2100", cart)
+	}
+
+	placed := s.buyWith(user, "/cartcheckout")
+	if placed.Total != 2100 {
+		t.Errorf("order total = %d, want 2100", placed.Total)
+	}
+}
@@ -5,27 +5,34 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/fredele20/e-commerce-cart/database"
 	"github.com/fredele20/e-commerce-cart/models"
-	"github.com/fredele20/e-commerce-cart/tokens"
 	"github.com/fredele20/e-commerce-cart/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var userCollection *mongo.Collection = database.UserData(database.Client, "Users")
-var productCollection = database.ProductData(database.Client, "Products")
 var Validate = validator.New()
 
+// signupRole grants the admin role to the configured admin emails so the first
+// administrators can be bootstrapped; everybody else signs up as a customer.
+func (app *Application) signupRole(email string) string {
+	for _, admin := range app.cfg.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleCustomer
+}
+
 
-func Signup() gin.HandlerFunc {
+func (app *Application) Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
 		var user models.User
@@ -40,8 +47,8 @@ func Signup() gin.HandlerFunc {
 			return
 		}
 
-		countEmail, _ := database.CheckUserAlreadyExist(ctx, "email", *user.Email)
-		countPhone, _ := database.CheckUserAlreadyExist(ctx, "phone", *user.Phone)
+		countEmail, _ := app.store.Users.CheckUserAlreadyExist(ctx, "email", *user.Email)
+		countPhone, _ := app.store.Users.CheckUserAlreadyExist(ctx, "phone", *user.Phone)
 
 		if countEmail > 0 || countPhone > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "user with this phone number or email already exists"})
@@ -49,25 +56,27 @@ func Signup() gin.HandlerFunc {
 		}
 
 
-		password := utils.HashPassword(*user.Password)
+		password := utils.HashPassword(*user.Password, app.cfg.BcryptCost)
 		user.Password = &password
 
 		user.Created_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.Updated_At, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.User_ID = user.ID.Hex()
+		user.Role = app.signupRole(*user.Email)
 
-		token, refereshToken, _ := tokens.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID)
+		session := app.tokens.NewSession()
+		token, refereshToken, _ := app.tokens.TokenGenerator(*user.Email, *user.First_Name, *user.Last_Name, user.User_ID, user.Role, user.Token_Version, session)
 
 		user.Token = &token
 		user.Referesh_Token = &refereshToken
 		user.UserCart = make([]models.ProductUser, 0)
 		user.Address_Details = make([]models.Address, 0)
-		user.Order_Status = make([]models.Order, 0)
+		user.Sessions = []models.Session{session}
 
-		_, insertErr := userCollection.InsertOne(ctx, user)
+		insertErr := app.store.Users.CreateUser(ctx, user)
 		if insertErr != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "the user was not created"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": insertErr.Error()})
 			return
 		}
 
@@ -77,20 +86,24 @@ func Signup() gin.HandlerFunc {
 	}
 }
 
-func Login() gin.HandlerFunc {
+func (app *Application) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
 		var user models.User
-		var founduser models.User
 
 		if err := c.BindJSON(&user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err})
 			return
 		}
 
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&founduser)
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		founduser, err := app.store.Users.FindUserByEmail(ctx, *user.Email)
 		defer cancel()
 
 		if err != nil {
@@ -107,58 +120,196 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		token, refereshtoken, _ := tokens.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID)
+		if founduser.Role == "" {
+			founduser.Role = models.RoleCustomer
+		}
+
+		session := app.tokens.NewSession()
+		token, refereshtoken, _ := app.tokens.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, founduser.Role, founduser.Token_Version, session)
 		defer cancel()
 
-		tokens.UpdateAllTokens(token, refereshtoken, founduser.User_ID)
+		if err := app.store.Users.AddSession(ctx, founduser.User_ID, session); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := app.store.Users.UpdateAllTokens(ctx, founduser.User_ID, token, refereshtoken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		founduser.Token = &token
+		founduser.Referesh_Token = &refereshtoken
 
 		c.JSON(http.StatusFound, founduser)
 	}
 
 }
 
-func ProductViewerAdmin() gin.HandlerFunc {
-	return func(ctx *gin.Context) {
+func (app *Application) RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Refresh_Token string `json:"refresh_token" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if validationErr := Validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		claims, msg := app.tokens.ValidateRefreshToken(body.Refresh_Token)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		founduser, err := app.store.Users.FindUserByID(ctx, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": database.ErrSessionNotFound.Error()})
+			return
+		}
+
+		if founduser.Role == "" {
+			founduser.Role = models.RoleCustomer
+		}
+
+		session := app.tokens.RenewSession(models.Session{Session_ID: claims.Session_ID})
+		token, refereshtoken, err := app.tokens.TokenGenerator(*founduser.Email, *founduser.First_Name, *founduser.Last_Name, founduser.User_ID, founduser.Role, founduser.Token_Version, session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the tokens were not generated"})
+			return
+		}
+
+		err = app.store.Users.RotateSession(ctx, founduser.User_ID, claims.Id, session)
+		switch err {
+		case nil:
+		case database.ErrSessionNotFound, database.ErrRefreshReused:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := app.store.Users.UpdateAllTokens(ctx, founduser.User_ID, token, refereshtoken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
+		c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refereshtoken})
 	}
 }
 
-func SearchProduct() gin.HandlerFunc {
+func (app *Application) Logout() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var productList []models.Product
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		cursor, err := productCollection.Find(ctx, bson.D{{}})
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, "something failed.")
+		if err := app.store.Users.RevokeSession(ctx, c.GetString("uid"), c.GetString("session_id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		err = cursor.All(ctx, &productList)
+		c.IndentedJSON(http.StatusOK, "successfully logged out")
+	}
+}
+
+func (app *Application) LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		if err := app.store.Users.RevokeAllSessions(ctx, c.GetString("uid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, "successfully logged out of all sessions")
+	}
+}
+
+func (app *Application) ForceLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		err := app.store.Users.RevokeAllSessions(ctx, c.Param("id"))
+		if err == database.ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			log.Println(err)
-			c.AbortWithStatus(http.StatusInternalServerError)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
-		defer cursor.Close(ctx)
+		log.Printf("admin %s force-logged out user %s", c.GetString("uid"), c.Param("id"))
+		c.IndentedJSON(http.StatusOK, "user successfully logged out of all sessions")
+	}
+}
 
-		if err := cursor.Err(); err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "invalid")
+func (app *Application) SetUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Role string `json:"role" validate:"required,oneof=ADMIN CUSTOMER"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		if validationErr := Validate.Struct(body); validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
+		err := app.store.Users.SetUserRole(ctx, c.Param("id"), body.Role)
+		if err == database.ErrUserNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the role was not updated"})
+			return
+		}
+
+		// roles are baked into issued tokens, so make the user sign in again
+		if err := app.store.Users.RevokeAllSessions(ctx, c.Param("id")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, "role successfully updated")
+	}
+}
+
+func (app *Application) SearchProduct() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		productList, err := app.store.Products.ListProducts(ctx)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError, "something failed.")
+			return
+		}
+
 		c.IndentedJSON(200, productList)
 	}
 }
 
-func SearchProductByQuery() gin.HandlerFunc {
+func (app *Application) SearchProductByQuery() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var searchProduct []models.Product
 		queryParam := c.Query("name")
 
 		if queryParam == "" {
@@ -169,31 +320,15 @@ func SearchProductByQuery() gin.HandlerFunc {
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
 		defer cancel()
 
-		searchQueryDB, err := productCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": queryParam}})
+		searchProduct, err := app.store.Products.SearchProducts(ctx, queryParam)
 		if err != nil {
 			c.IndentedJSON(404, "something failed while searching DB")
 			return
 		}
 
-		err = searchQueryDB.All(ctx, &searchProduct)
-		if err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "invalid")
-			return
-		}
-
-		defer searchQueryDB.Close(ctx)
-
-		if err := searchQueryDB.Err(); err != nil {
-			log.Println(err)
-			c.IndentedJSON(400, "invalid request")
-			return
-		}
-
-		defer cancel()
 		c.IndentedJSON(200, searchProduct)
 	}
 }
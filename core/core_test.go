@@ -0,0 +1,231 @@
+package core_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/container"
+	"github.com/fredele20/e-commerce-cart/core"
+	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/routes"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	adminEmail    = "admin@example.com"
+	webhookSecret = "test-webhook-secret"
+)
+
+// server runs the whole API over the in-memory store.
+type server struct {
+	t      *testing.T
+	router *gin.Engine
+	store  *database.Store
+}
+
+// newServer starts the API over a fresh in-memory store, with the test
+// configuration adjusted by configure.
+func newServer(t *testing.T, configure ...func(*config.Config)) *server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Storage = "memory"
+	cfg.JWT.Secret = "test-secret"
+	cfg.JWT.RefreshSecret = "test-secret"
+	cfg.BcryptCost = bcrypt.MinCost
+	cfg.AdminEmails = []string{adminEmail}
+	cfg.Payments.Provider = "fake"
+	cfg.Payments.WebhookSecret = webhookSecret
+	for _, change := range configure {
+		change(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := container.New(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := core.NewApplication(c)
+	router := gin.New()
+	routes.UserRoutes(router, app, c)
+	routes.AdminRoutes(router, app, c)
+	routes.CartRoutes(router, app, c)
+
+	return &server{t: t, router: router, store: c.Store}
+}
+
+// do sends a JSON request as the holder of token, with extra header name and
+// value pairs. A []byte body is sent as is.
+func (s *server) do(method, path, token string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var payload bytes.Buffer
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		payload.Write(body)
+	default:
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("token", token)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// expect checks the status of a response and decodes its JSON body into out,
+// if given.
+func (s *server) expect(recorder *httptest.ResponseRecorder, status int, out interface{}) {
+	s.t.Helper()
+	if recorder.Code != status {
+		s.t.Fatalf("status = %d, want %d: %s", recorder.Code, status, recorder.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("cannot decode %s: %v", recorder.Body.String(), err)
+		}
+	}
+}
+
+type account struct {
+	Token          string `json:"token"`
+	Referesh_Token string `json:"referesh_token"`
+	User_ID        string `json:"user_id"`
+}
+
+func (s *server) signup(email, phone string) account {
+	s.t.Helper()
+	var user account
+	s.expect(s.do(http.MethodPost, "/users/signup", "", map[string]string{
+		"first_name": "Ada",
+		"last_name":  "Obi",
+		"password":   "secret123",
+		"email":      email,
+		"phone":      phone,
+	}), http.StatusCreated, &user)
+	return user
+}
+
+// customer signs up a customer with a default shipping address.
+func (s *server) customer() account {
+	s.t.Helper()
+	user := s.signup("ada@example.com", "08011111111")
+	s.expect(s.do(http.MethodPost, "/addresses", user.Token, map[string]string{
+		"country":     "NG",
+		"state":       "Lagos",
+		"street_name": "Allen Avenue",
+		"city_name":   "Ikeja",
+	}), http.StatusCreated, nil)
+	return user
+}
+
+// cart reads the quantities in the user's cart straight from the store.
+func (s *server) cart(user account) map[string]int {
+	s.t.Helper()
+	cart, err := s.store.Carts.GetCart(context.Background(), user.User_ID)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	quantities := make(map[string]int)
+	for _, item := range cart.Items {
+		quantities[item.Product_ID.Hex()] = item.Quantity
+	}
+	return quantities
+}
+
+type product struct {
+	ID    string `json:"_id"`
+	Stock *int   `json:"stock"`
+}
+
+func (s *server) addProduct(admin account, price, stock int) product {
+	s.t.Helper()
+	var created product
+	s.expect(s.do(http.MethodPost, "/admin/addproduct", admin.Token, map[string]interface{}{
+		"product_name": "Desk lamp",
+		"price":        price,
+		"image":        "https://example.com/lamp.png",
+		"stock":        stock,
+	}), http.StatusCreated, &created)
+	return created
+}
+
+func (s *server) stock(admin account, productID string) int {
+	s.t.Helper()
+	var found product
+	s.expect(s.do(http.MethodGet, "/admin/products/"+productID, admin.Token, nil), http.StatusOK, &found)
+	if found.Stock == nil {
+		s.t.Fatal("product is not tracking stock")
+	}
+	return *found.Stock
+}
+
+type order struct {
+	ID     string `json:"_id"`
+	Total  int    `json:"total_price"`
+	Status string `json:"status"`
+	Lines  []struct {
+		Quantity int `json:"quantity"`
+	} `json:"order_list"`
+	Payment struct {
+		Method     string `json:"method"`
+		Payment_ID string `json:"payment_id"`
+		Status     string `json:"status"`
+	} `json:"payment_method"`
+}
+
+func TestLoginIssuesFreshTokens(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+
+	var found account
+	s.expect(s.do(http.MethodPost, "/users/login", "", map[string]string{
+		"email":    "ada@example.com",
+		"password": "secret123",
+	}), http.StatusFound, &found)
+	if found.User_ID != user.User_ID || found.Token == "" || found.Referesh_Token == "" {
+		t.Errorf("login answered %+v for user %s", found, user.User_ID)
+	}
+
+	s.expect(s.do(http.MethodPost, "/users/login", "", map[string]string{
+		"email":    "ada@example.com",
+		"password": "wrong-password",
+	}), http.StatusInternalServerError, nil)
+}
+
+func TestAdminRoutesRequireTheAdminRole(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+	admin := s.signup(adminEmail, "08022222222")
+
+	s.expect(s.do(http.MethodGet, "/admin/products", "", nil), http.StatusUnauthorized, nil)
+	s.expect(s.do(http.MethodGet, "/admin/products", user.Token, nil), http.StatusForbidden, nil)
+
+	created := s.addProduct(admin, 1500, 10)
+	var listed []product
+	s.expect(s.do(http.MethodGet, "/users/productview", "", nil), http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].ID != created.ID {
+		t.Errorf("product view = %+v, want the product %s", listed, created.ID)
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayHeader marks a response replayed for a repeated key.
+	IdempotentReplayHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// recordingWriter keeps a copy of the response body written through it.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotent makes the handlers after it safe to retry. A request sent with
+// an Idempotency-Key runs once per user and key; repeats within the
+// configured window get the first successful response back instead of running
+// again. Failed requests release the key so they can be retried with it.
+func (app *Application) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "the Idempotency-Key header is too long"})
+			return
+		}
+
+		// admins acting for a customer are part of the request, not the key
+		userID := c.GetString("uid")
+		fingerprint := c.Request.Method + " " + c.Request.URL.RequestURI() + " " + c.GetHeader(ImpersonationHeader)
+
+		now := time.Now()
+		record := models.IdempotencyRecord{
+			User_ID:     userID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			Created_At:  now,
+			Expires_At:  now.Add(app.cfg.Checkout.IdempotencyWindow.Std()),
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		existing, err := app.store.Idempotency.ReserveKey(ctx, record)
+		cancel()
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "this Idempotency-Key was already used for a different request"})
+			case !existing.Completed:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header(IdempotentReplayHeader, "true")
+				c.Data(existing.Status, existing.Content_Type, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		if status := writer.Status(); status >= 200 && status < 300 {
+			err = app.store.Idempotency.CompleteKey(ctx, userID, key, status, writer.Header().Get("Content-Type"), writer.body.Bytes())
+		} else {
+			err = app.store.Idempotency.ReleaseKey(ctx, userID, key)
+		}
+		if err != nil {
+			log.Printf("cannot settle idempotency key %q of user %s: %v", key, userID, err)
+		}
+	}
+}
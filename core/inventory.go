@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stockUnavailable answers a request asking for more units of a product than
+// are left.
+func stockUnavailable(c *gin.Context, productID primitive.ObjectID) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":      database.ErrInsufficientStock.Error(),
+		"code":       "insufficient_stock",
+		"product_id": productID,
+	})
+}
+
+func (app *Application) reservationsEnabled() bool {
+	return app.cfg.Inventory.ReservationTTL > 0
+}
+
+// lineQuantity returns how many units of the product are in the user's cart.
+func (app *Application) lineQuantity(ctx context.Context, userID string, productID primitive.ObjectID) (int, error) {
+	cart, err := app.store.Carts.GetCart(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range cart.Items {
+		if item.Product_ID == productID {
+			return item.Quantity, nil
+		}
+	}
+	return 0, nil
+}
+
+// holdStock makes sure a cart line of quantity units can be filled. With
+// reservations enabled the units are held for the user, otherwise the stock
+// is only checked and gets taken at checkout.
+func (app *Application) holdStock(ctx context.Context, userID string, product *models.Product, quantity int) error {
+	if !app.reservationsEnabled() {
+		if product.Stock != nil && quantity > *product.Stock {
+			return database.ErrInsufficientStock
+		}
+		return nil
+	}
+
+	expiresAt := time.Now().Add(app.cfg.Inventory.ReservationTTL.Std())
+	return app.store.Inventory.ReserveStock(ctx, product.Product_ID, userID, quantity, expiresAt)
+}
+
+// syncHold brings the user's hold on a product back in line with the cart
+// after the line shrank, was removed or failed to change.
+func (app *Application) syncHold(ctx context.Context, userID string, productID primitive.ObjectID) {
+	if !app.reservationsEnabled() {
+		return
+	}
+
+	quantity, err := app.lineQuantity(ctx, userID, productID)
+	if err == nil {
+		expiresAt := time.Now().Add(app.cfg.Inventory.ReservationTTL.Std())
+		err = app.store.Inventory.ReserveStock(ctx, productID, userID, quantity, expiresAt)
+	}
+	if err != nil {
+		log.Printf("cannot sync the stock held for user %s on product %s: %v", userID, productID.Hex(), err)
+	}
+}
+
+// commitStock takes the stock for every item being ordered. On failure the
+// units already taken are put back and the item that could not be filled is
+// returned alongside the error.
+func (app *Application) commitStock(ctx context.Context, userID string, items []models.ProductUser) (*models.ProductUser, error) {
+	for i, item := range items {
+		if err := app.store.Inventory.CommitStock(ctx, item.Product_ID, userID, item.Quantity); err != nil {
+			app.restoreStock(ctx, items[:i])
+			return &items[i], err
+		}
+	}
+	return nil, nil
+}
+
+// restoreStock puts the units of an order that could not be placed back on
+// sale.
+func (app *Application) restoreStock(ctx context.Context, items []models.ProductUser) {
+	for _, item := range items {
+		if err := app.store.Inventory.RestoreStock(ctx, item.Product_ID, item.Quantity); err != nil {
+			log.Printf("cannot restore %d units of product %s: %v", item.Quantity, item.Product_ID.Hex(), err)
+		}
+	}
+}
+
+// ReleaseExpiredReservations returns the stock of expired cart holds until ctx
+// is done. It does nothing when reservations are disabled.
+func (app *Application) ReleaseExpiredReservations(ctx context.Context) {
+	if !app.reservationsEnabled() {
+		return
+	}
+
+	ticker := time.NewTicker(app.cfg.Inventory.SweepInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sweepCtx, cancel := context.WithTimeout(ctx, app.cfg.RequestTimeout.Std())
+			released, err := app.store.Inventory.ReleaseExpiredReservations(sweepCtx, now)
+			cancel()
+			if err != nil {
+				log.Printf("cannot release expired reservations: %v", err)
+			}
+			if released > 0 {
+				log.Printf("released %d expired reservations", released)
+			}
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/database"
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func orderStatus(err error) int {
+	switch err {
+	case database.ErrOrderNotFound:
+		return http.StatusNotFound
+	case database.ErrOrderStatusChanged:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// pageParams reads the page (from 1) and limit query parameters.
+func pageParams(c *gin.Context) (int, int, error) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		return 0, 0, fmt.Errorf("page must be a positive number")
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+
+	return page, limit, nil
+}
+
+// restocks reports whether the goods of an order moving between the two
+// statuses go back on sale: cancelled orders and orders refunded before they
+// shipped never left, approved returns came back. Cancelled orders were
+// restocked when they were cancelled.
+func restocks(from, to string) bool {
+	switch to {
+	case models.OrderCancelled:
+		return true
+	case models.OrderRefunded:
+		return from == models.OrderPaid || from == models.OrderPacked || from == models.OrderReturnRequested
+	}
+	return false
+}
+
+// moveOrder moves an order to the status to, provided the state machine
+// allows it from the order's current status, and restocks its items when
+// the move puts them back on sale.
+func (app *Application) moveOrder(ctx context.Context, order *models.Order, to, reason string) (*models.Order, int, error) {
+	from := order.CurrentStatus()
+	if !models.CanTransition(from, to) {
+		return nil, http.StatusConflict, fmt.Errorf("an order cannot move from %s to %s", from, to)
+	}
+
+	payment, err := app.settlePayment(ctx, order, to)
+	if err != nil {
+		return nil, paymentStatus(err), err
+	}
+
+	change := models.StatusChange{Status: to, At: time.Now(), Reason: reason}
+	updated, err := app.store.Orders.UpdateOrderStatus(ctx, order.Order_ID, from, change)
+	if err != nil {
+		if payment != nil {
+			log.Printf("order %s changed while its payment was settled, payment is now %s", order.Order_ID.Hex(), payment.Status)
+		}
+		return nil, orderStatus(err), err
+	}
+
+	if payment != nil {
+		if err := app.store.Orders.SetOrderPayment(ctx, order.Order_ID, *payment); err != nil {
+			log.Printf("cannot record payment %s of order %s as %s: %v", payment.Payment_ID, order.Order_ID.Hex(), payment.Status, err)
+		}
+		updated.Payment_Method = *payment
+	}
+
+	if restocks(from, to) {
+		app.restoreStock(ctx, order.Order_Cart)
+	}
+
+	return updated, http.StatusOK, nil
+}
+
+func (app *Application) advanceOrder(ctx context.Context, orderID primitive.ObjectID, to, reason string) (*models.Order, int, error) {
+	order, err := app.store.Orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, orderStatus(err), err
+	}
+
+	return app.moveOrder(ctx, order, to, reason)
+}
+
+// userOrder returns one of the user's orders. Other users' orders are
+// reported as missing rather than forbidden.
+func (app *Application) userOrder(ctx context.Context, userID string, orderID primitive.ObjectID) (*models.Order, error) {
+	order, err := app.store.Orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.User_ID != userID {
+		return nil, database.ErrOrderNotFound
+	}
+
+	return order, nil
+}
+
+func (app *Application) GetOrderAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, err := app.store.Orders.GetOrder(ctx, orderID)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+func (app *Application) UpdateOrderStatusAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var body struct {
+			Status string `json:"status"`
+			Reason string `json:"reason"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !models.ValidOrderStatus(body.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown order status %q", body.Status)})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, status, err := app.advanceOrder(ctx, orderID, body.Status, body.Reason)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		page, limit, err := pageParams(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		orders, total, err := app.store.Orders.ListUserOrders(ctx, userID, (page-1)*limit, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{
+			"orders": orders,
+			"page":   page,
+			"limit":  limit,
+			"total":  total,
+		})
+	}
+}
+
+func (app *Application) GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, err := app.userOrder(ctx, userID, orderID)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// customerOrderAction handles a customer moving one of their own orders from
+// the status from to the status to. Action names the move in errors.
+func (app *Application) customerOrderAction(from, to, action string, reasonRequired bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, status, err := actingUserID(c)
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if reasonRequired && strings.TrimSpace(body.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a reason is required"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, err := app.userOrder(ctx, userID, orderID)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if order.CurrentStatus() != from {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("only %s orders can be %s", from, action)})
+			return
+		}
+
+		order, status, err = app.moveOrder(ctx, order, to, strings.TrimSpace(body.Reason))
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// CancelOrder lets customers cancel their orders until they are paid.
+func (app *Application) CancelOrder() gin.HandlerFunc {
+	return app.customerOrderAction(models.OrderPending, models.OrderCancelled, "cancelled", false)
+}
+
+// RequestReturn lets customers ask to send back a delivered order, saying why.
+func (app *Application) RequestReturn() gin.HandlerFunc {
+	return app.customerOrderAction(models.OrderDelivered, models.OrderReturnRequested, "returned", true)
+}
+
+func (app *Application) decideReturn(to string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID, err := primitive.ObjectIDFromHex(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var body struct {
+			Reason string `json:"reason"`
+		}
+		if c.Request.ContentLength != 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, err := app.store.Orders.GetOrder(ctx, orderID)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if order.CurrentStatus() != models.OrderReturnRequested {
+			c.JSON(http.StatusConflict, gin.H{"error": "no return was requested for this order"})
+			return
+		}
+
+		order, status, err := app.moveOrder(ctx, order, to, strings.TrimSpace(body.Reason))
+		if err != nil {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.IndentedJSON(http.StatusOK, order)
+	}
+}
+
+// ApproveReturnAdmin refunds a returned order and puts its items back on sale.
+func (app *Application) ApproveReturnAdmin() gin.HandlerFunc {
+	return app.decideReturn(models.OrderRefunded)
+}
+
+// RejectReturnAdmin turns a return request down, leaving the order delivered.
+func (app *Application) RejectReturnAdmin() gin.HandlerFunc {
+	return app.decideReturn(models.OrderDelivered)
+}
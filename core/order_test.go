@@ -0,0 +1,160 @@
+package core_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+type orderPage struct {
+	Orders []order `json:"orders"`
+	Page   int     `json:"page"`
+	Total  int     `json:"total"`
+}
+
+// buy places an instant order of quantity units and returns it.
+func (s *server) buy(user account, productID string, quantity int) order {
+	s.t.Helper()
+	return s.buyWith(user, "/instantbuy?id="+productID+"&quantity="+strconv.Itoa(quantity))
+}
+
+// buyWith places the order described by path and returns it.
+func (s *server) buyWith(user account, path string) order {
+	s.t.Helper()
+	var placed order
+	s.expect(s.do(http.MethodGet, path, user.Token, nil), http.StatusOK, &placed)
+	return placed
+}
+
+func (s *server) moveOrder(admin account, orderID string, statuses ...string) {
+	s.t.Helper()
+	for _, status := range statuses {
+		s.expect(s.do(http.MethodPut, "/admin/orders/"+orderID+"/status", admin.Token, map[string]string{"status": status}), http.StatusOK, nil)
+	}
+}
+
+func TestOrderHistoryIsPaginatedPerUser(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	other := s.signup("obi@example.com", "08033333333")
+	lamp := s.addProduct(admin, 1500, 10)
+
+	for i := 0; i < 3; i++ {
+		s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID, user.Token, nil), http.StatusOK, nil)
+	}
+
+	var first, second orderPage
+	s.expect(s.do(http.MethodGet, "/orders?page=1&limit=2", user.Token, nil), http.StatusOK, &first)
+	s.expect(s.do(http.MethodGet, "/orders?page=2&limit=2", user.Token, nil), http.StatusOK, &second)
+	if len(first.Orders) != 2 || len(second.Orders) != 1 || first.Total != 3 || second.Page != 2 {
+		t.Fatalf("pages = %+v and %+v, want 2 and 1 of 3 orders", first, second)
+	}
+
+	placed := second.Orders[0]
+	var fetched order
+	s.expect(s.do(http.MethodGet, "/orders/"+placed.ID, user.Token, nil), http.StatusOK, &fetched)
+	if fetched.ID != placed.ID || fetched.Total != 1500 || len(fetched.Lines) != 1 {
+		t.Errorf("fetched order = %+v, want %s", fetched, placed.ID)
+	}
+
+	var others orderPage
+	s.expect(s.do(http.MethodGet, "/orders", other.Token, nil), http.StatusOK, &others)
+	if len(others.Orders) != 0 || others.Total != 0 {
+		t.Errorf("another user sees %+v", others)
+	}
+	s.expect(s.do(http.MethodGet, "/orders/"+placed.ID, other.Token, nil), http.StatusNotFound, nil)
+	s.expect(s.do(http.MethodGet, "/orders?limit=0", user.Token, nil), http.StatusBadRequest, nil)
+}
+
+func TestCancellingAPendingOrderRestocks(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	other := s.signup("obi@example.com", "08033333333")
+	lamp := s.addProduct(admin, 1500, 5)
+
+	placed := s.buy(user, lamp.ID, 2)
+	s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/cancel", other.Token, nil), http.StatusNotFound, nil)
+
+	var cancelled order
+	s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/cancel", user.Token, map[string]string{"reason": "changed my mind"}), http.StatusOK, &cancelled)
+	if cancelled.Status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", cancelled.Status)
+	}
+	if stock := s.stock(admin, lamp.ID); stock != 5 {
+		t.Errorf("stock = %d, want 5", stock)
+	}
+
+	s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/cancel", user.Token, nil), http.StatusConflict, nil)
+
+	paid := s.buy(user, lamp.ID, 1)
+	s.moveOrder(admin, paid.ID, "paid")
+	s.expect(s.do(http.MethodPost, "/orders/"+paid.ID+"/cancel", user.Token, nil), http.StatusConflict, nil)
+}
+
+func TestReturnsRestockOnlyOnceApproved(t *testing.T) {
+	tests := []struct {
+		name     string
+		decision string
+		status   string
+		stock    int
+	}{
+		{"approved", "approve", "refunded", 5},
+		{"rejected", "reject", "delivered", 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newServer(t)
+			admin := s.signup(adminEmail, "08022222222")
+			user := s.customer()
+			lamp := s.addProduct(admin, 1500, 5)
+
+			placed := s.buy(user, lamp.ID, 2)
+			s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/return", user.Token, map[string]string{"reason": "broken"}), http.StatusConflict, nil)
+
+			s.moveOrder(admin, placed.ID, "paid", "packed", "shipped", "delivered")
+			s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/return", user.Token, nil), http.StatusBadRequest, nil)
+			s.expect(s.do(http.MethodPost, "/orders/"+placed.ID+"/return", user.Token, map[string]string{"reason": "broken"}), http.StatusOK, nil)
+
+			var decided order
+			s.expect(s.do(http.MethodPost, "/admin/orders/"+placed.ID+"/return/"+test.decision, admin.Token, nil), http.StatusOK, &decided)
+			if decided.Status != test.status {
+				t.Errorf("status = %q, want %q", decided.Status, test.status)
+			}
+			if stock := s.stock(admin, lamp.ID); stock != test.stock {
+				t.Errorf("stock = %d, want %d", stock, test.stock)
+			}
+		})
+	}
+}
+
+func TestRefundRestocksOnlyGoodsThatDidNotShip(t *testing.T) {
+	tests := []struct {
+		name  string
+		moves []string
+		stock int
+	}{
+		{"refunded once paid", []string{"paid", "refunded"}, 5},
+		{"refunded once packed", []string{"paid", "packed", "refunded"}, 5},
+		{"cancelled then refunded", []string{"paid", "cancelled", "refunded"}, 5},
+		{"refunded after delivery without a return", []string{"paid", "packed", "shipped", "delivered", "refunded"}, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newServer(t)
+			admin := s.signup(adminEmail, "08022222222")
+			user := s.customer()
+			lamp := s.addProduct(admin, 1500, 5)
+
+			placed := s.buy(user, lamp.ID, 2)
+			s.moveOrder(admin, placed.ID, test.moves...)
+
+			if stock := s.stock(admin, lamp.ID); stock != test.stock {
+				t.Errorf("stock = %d, want %d", stock, test.stock)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/fredele20/e-commerce-cart/payments"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentSignatureHeader carries the provider's signature of a webhook body.
+const PaymentSignatureHeader = "X-Payment-Signature"
+
+var ErrDigitalPaymentsDisabled = errors.New("digital payments are not available")
+
+func paymentStatus(err error) int {
+	switch err {
+	case payments.ErrDeclined:
+		return http.StatusPaymentRequired
+	case ErrDigitalPaymentsDisabled:
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
+
+// paymentMethod reads the payment query parameter of a checkout, which
+// defaults to cash on delivery.
+func (app *Application) paymentMethod(c *gin.Context) (string, error) {
+	method := c.DefaultQuery("payment", models.PaymentCOD)
+	switch method {
+	case models.PaymentCOD:
+	case models.PaymentDigital:
+		if app.payments == nil {
+			return "", ErrDigitalPaymentsDisabled
+		}
+	default:
+		return "", fmt.Errorf("payment must be %s or %s", models.PaymentCOD, models.PaymentDigital)
+	}
+	return method, nil
+}
+
+// authorizePayment reserves the total of a digitally paid order with the
+// provider and records the payment on the order.
+func (app *Application) authorizePayment(ctx context.Context, order *models.Order, method, token string) error {
+	if method != models.PaymentDigital {
+		return nil
+	}
+
+	transaction, err := app.payments.Authorize(ctx, payments.AuthorizeRequest{
+		Order_ID: order.Order_ID.Hex(),
+		Amount:   order.Price,
+		Token:    token,
+	})
+	if err != nil {
+		return err
+	}
+
+	order.Payment_Method = models.Payment{
+		Digital:    true,
+		Method:     models.PaymentDigital,
+		Provider:   app.payments.Name(),
+		Payment_ID: transaction.Payment_ID,
+		Status:     models.PaymentAuthorized,
+	}
+	return nil
+}
+
+// voidPayment releases the authorization of an order that could not be
+// placed.
+func (app *Application) voidPayment(ctx context.Context, order *models.Order) {
+	payment := order.Payment_Method
+	if payment.Method != models.PaymentDigital || payment.Status != models.PaymentAuthorized {
+		return
+	}
+
+	if _, err := app.payments.Refund(ctx, payment.Payment_ID, order.Price); err != nil {
+		log.Printf("cannot void payment %s of order %s: %v", payment.Payment_ID, order.Order_ID.Hex(), err)
+	}
+}
+
+// settlePayment moves the money of a digitally paid order along with its
+// status: it is captured when the order is paid, released when the order is
+// cancelled before that and given back when the order is refunded. It returns
+// the payment to store, or nil when nothing changed.
+func (app *Application) settlePayment(ctx context.Context, order *models.Order, to string) (*models.Payment, error) {
+	payment := order.Payment_Method
+	if payment.Method != models.PaymentDigital || payment.Payment_ID == "" {
+		return nil, nil
+	}
+
+	if app.payments == nil || app.payments.Name() != payment.Provider {
+		return nil, fmt.Errorf("payment provider %q is not available", payment.Provider)
+	}
+
+	var err error
+	switch {
+	case to == models.OrderPaid && payment.Status == models.PaymentAuthorized:
+		_, err = app.payments.Capture(ctx, payment.Payment_ID, order.Price)
+		payment.Status = models.PaymentCaptured
+	case to == models.OrderCancelled && payment.Status == models.PaymentAuthorized:
+		_, err = app.payments.Refund(ctx, payment.Payment_ID, order.Price)
+		payment.Status = models.PaymentVoided
+	case to == models.OrderRefunded && payment.Status == models.PaymentCaptured:
+		_, err = app.payments.Refund(ctx, payment.Payment_ID, order.Price)
+		payment.Status = models.PaymentRefunded
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// PaymentWebhook applies the events the payment provider reports on its own,
+// such as payments captured, refunded or failed outside of this service.
+func (app *Application) PaymentWebhook() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if app.payments == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": ErrDigitalPaymentsDisabled.Error()})
+			return
+		}
+
+		payload, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		event, err := app.payments.VerifyWebhook(payload, c.GetHeader(PaymentSignatureHeader))
+		if err == payments.ErrInvalidSignature {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		orderID, err := primitive.ObjectIDFromHex(event.Order_ID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid order id"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), app.cfg.RequestTimeout.Std())
+		defer cancel()
+
+		order, err := app.store.Orders.GetOrder(ctx, orderID)
+		if err == nil && order.Payment_Method.Payment_ID != event.Payment_ID {
+			err = errors.New("the payment does not belong to this order")
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		var paymentState, to, reason string
+		switch event.Type {
+		case payments.EventCaptured:
+			paymentState, to = models.PaymentCaptured, models.OrderPaid
+		case payments.EventRefunded:
+			paymentState, to = models.PaymentRefunded, models.OrderRefunded
+		case payments.EventFailed:
+			paymentState, to, reason = models.PaymentVoided, models.OrderCancelled, "payment failed"
+		default:
+			// acknowledge events we do not act on so they are not redelivered
+			c.IndentedJSON(http.StatusOK, gin.H{"received": true})
+			return
+		}
+
+		// the provider already moved the money, so record that first and
+		// let the order follow without settling the payment again
+		order.Payment_Method.Status = paymentState
+		if err := app.store.Orders.SetOrderPayment(ctx, orderID, order.Payment_Method); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if models.CanTransition(order.CurrentStatus(), to) {
+			if _, status, err := app.moveOrder(ctx, order, to, reason); err != nil {
+				c.JSON(status, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.IndentedJSON(http.StatusOK, gin.H{"received": true})
+	}
+}
@@ -0,0 +1,92 @@
+package core_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/core"
+	"github.com/fredele20/e-commerce-cart/payments"
+)
+
+func TestDigitalPaymentsFollowTheOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   []string
+		payment string
+	}{
+		{"placed orders are authorized", nil, "authorized"},
+		{"paid orders are captured", []string{"paid"}, "captured"},
+		{"cancelled orders are voided", []string{"cancelled"}, "voided"},
+		{"refunded orders are refunded", []string{"paid", "refunded"}, "refunded"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newServer(t)
+			admin := s.signup(adminEmail, "08022222222")
+			user := s.customer()
+			lamp := s.addProduct(admin, 1500, 5)
+
+			placed := s.buyWith(user, "/instantbuy?id="+lamp.ID+"&payment=digital&payment_token=tok_visa")
+			if placed.Payment.Method != "digital" || placed.Payment.Payment_ID == "" {
+				t.Fatalf("payment = %+v, want a digital payment", placed.Payment)
+			}
+
+			s.moveOrder(admin, placed.ID, test.moves...)
+
+			var fetched order
+			s.expect(s.do(http.MethodGet, "/orders/"+placed.ID, user.Token, nil), http.StatusOK, &fetched)
+			if fetched.Payment.Status != test.payment {
+				t.Errorf("payment status = %q, want %q", fetched.Payment.Status, test.payment)
+			}
+		})
+	}
+}
+
+func TestDeclinedPaymentsPlaceNoOrder(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 5)
+
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&payment=digital&payment_token="+payments.FakeDeclineToken, user.Token, nil), http.StatusPaymentRequired, nil)
+	s.expect(s.do(http.MethodGet, "/instantbuy?id="+lamp.ID+"&payment=cheque", user.Token, nil), http.StatusBadRequest, nil)
+
+	var history orderPage
+	s.expect(s.do(http.MethodGet, "/orders", user.Token, nil), http.StatusOK, &history)
+	if history.Total != 0 {
+		t.Errorf("orders = %+v, want none", history)
+	}
+	if stock := s.stock(admin, lamp.ID); stock != 5 {
+		t.Errorf("stock = %d, want 5", stock)
+	}
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	lamp := s.addProduct(admin, 1500, 5)
+
+	placed := s.buyWith(user, "/instantbuy?id="+lamp.ID+"&payment=digital&payment_token=tok_visa")
+	payload, err := json.Marshal(payments.Event{
+		Type:       payments.EventCaptured,
+		Payment_ID: placed.Payment.Payment_ID,
+		Order_ID:   placed.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(s.do(http.MethodPost, "/payments/webhook", "", payload, core.PaymentSignatureHeader, "forged"), http.StatusUnauthorized, nil)
+
+	signature := payments.NewFake(webhookSecret).Sign(payload)
+	s.expect(s.do(http.MethodPost, "/payments/webhook", "", payload, core.PaymentSignatureHeader, signature), http.StatusOK, nil)
+
+	var fetched order
+	s.expect(s.do(http.MethodGet, "/orders/"+placed.ID, user.Token, nil), http.StatusOK, &fetched)
+	if fetched.Status != "paid" || fetched.Payment.Status != "captured" {
+		t.Errorf("order is %s with a %s payment, want paid and captured", fetched.Status, fetched.Payment.Status)
+	}
+}
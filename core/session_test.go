@@ -0,0 +1,90 @@
+package core_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (s *server) login(email string) account {
+	s.t.Helper()
+	var user account
+	s.expect(s.do(http.MethodPost, "/users/login", "", map[string]string{
+		"email":    email,
+		"password": "secret123",
+	}), http.StatusFound, &user)
+	return user
+}
+
+// revoked checks that token no longer gets past authentication.
+func (s *server) revoked(token string) {
+	s.t.Helper()
+	recorder := s.do(http.MethodGet, "/cart", token, nil)
+	if recorder.Code != http.StatusUnauthorized || !strings.Contains(recorder.Body.String(), "revoked") {
+		s.t.Errorf("revoked token answered %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestLogoutEndsOnlyTheCurrentSession(t *testing.T) {
+	s := newServer(t)
+	phone := s.customer()
+	laptop := s.login("ada@example.com")
+
+	s.expect(s.do(http.MethodPost, "/users/logout", phone.Token, nil), http.StatusOK, nil)
+	s.revoked(phone.Token)
+	s.expect(s.do(http.MethodPost, "/users/refresh", "", map[string]string{"refresh_token": phone.Referesh_Token}), http.StatusUnauthorized, nil)
+
+	s.expect(s.do(http.MethodGet, "/cart", laptop.Token, nil), http.StatusOK, nil)
+}
+
+func TestLogoutAllEndsEverySession(t *testing.T) {
+	s := newServer(t)
+	phone := s.customer()
+	laptop := s.login("ada@example.com")
+
+	s.expect(s.do(http.MethodPost, "/users/logout/all", laptop.Token, nil), http.StatusOK, nil)
+	s.revoked(phone.Token)
+	s.revoked(laptop.Token)
+
+	again := s.login("ada@example.com")
+	s.expect(s.do(http.MethodGet, "/cart", again.Token, nil), http.StatusOK, nil)
+}
+
+func TestAdminsCanForceALogout(t *testing.T) {
+	s := newServer(t)
+	admin := s.signup(adminEmail, "08022222222")
+	user := s.customer()
+	other := s.signup("obi@example.com", "08033333333")
+
+	s.expect(s.do(http.MethodPost, "/admin/users/"+other.User_ID+"/logout", user.Token, nil), http.StatusForbidden, nil)
+	s.expect(s.do(http.MethodPost, "/admin/users/64b000000000000000000000/logout", admin.Token, nil), http.StatusNotFound, nil)
+
+	s.expect(s.do(http.MethodPost, "/admin/users/"+user.User_ID+"/logout", admin.Token, nil), http.StatusOK, nil)
+	s.revoked(user.Token)
+	s.expect(s.do(http.MethodGet, "/cart", other.Token, nil), http.StatusOK, nil)
+}
+
+func TestMissingOrInvalidTokensAreUnauthorized(t *testing.T) {
+	s := newServer(t)
+
+	s.expect(s.do(http.MethodGet, "/cart", "", nil), http.StatusUnauthorized, nil)
+	s.expect(s.do(http.MethodGet, "/cart", "not-a-token", nil), http.StatusUnauthorized, nil)
+}
+
+func TestRefreshTokenReuseRevokesTheSession(t *testing.T) {
+	s := newServer(t)
+	user := s.customer()
+
+	var rotated struct {
+		Token         string `json:"token"`
+		Refresh_Token string `json:"refresh_token"`
+	}
+	s.expect(s.do(http.MethodPost, "/users/refresh", "", map[string]string{"refresh_token": user.Referesh_Token}), http.StatusOK, &rotated)
+	s.expect(s.do(http.MethodGet, "/cart", rotated.Token, nil), http.StatusOK, nil)
+
+	// replaying the rotated refresh token is treated as theft
+	s.expect(s.do(http.MethodPost, "/users/refresh", "", map[string]string{"refresh_token": user.Referesh_Token}), http.StatusUnauthorized, nil)
+
+	s.expect(s.do(http.MethodPost, "/users/refresh", "", map[string]string{"refresh_token": rotated.Refresh_Token}), http.StatusUnauthorized, nil)
+	s.revoked(rotated.Token)
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"errors"
 	"log"
-	"time"
 
 	"github.com/fredele20/e-commerce-cart/models"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -20,165 +20,254 @@ var (
 	ErrCantGetItem           = errors.New("unable to get cart item")
 	ErrCantBuyCartItem       = errors.New("cannot update the purchase")
 	ErrCantUpdateUser        = errors.New("cannot add this product to the cart")
+	ErrCartEmpty             = errors.New("the cart is empty")
+	ErrCartChanged           = errors.New("the cart changed during checkout, please review it and try again")
+	ErrCartItemNotFound      = errors.New("this product is not in the cart")
+	ErrQuantityLimit         = errors.New("the quantity exceeds the maximum allowed for this product")
 )
 
-func AddProductToCart(ctx context.Context, productCollection, userCollection *mongo.Collection, productId primitive.ObjectID, userId string) error {
-	searchFromDB, err := productCollection.Find(ctx, bson.M{"_id": productId})
-	if err != nil {
-		log.Println(err)
-		return ErrProductNotFound
+// normalizeCart folds the duplicate entries and missing quantities of carts
+// written before cart lines carried a quantity. Lines without a product id
+// cannot be priced or bought, so they are dropped rather than folded into one.
+func normalizeCart(items []models.ProductUser) []models.ProductUser {
+	lines := make([]models.ProductUser, 0, len(items))
+	index := make(map[primitive.ObjectID]int, len(items))
+
+	for _, item := range items {
+		if item.Product_ID.IsZero() {
+			continue
+		}
+		if item.Quantity <= 0 {
+			item.Quantity = 1
+		}
+
+		if i, ok := index[item.Product_ID]; ok {
+			lines[i].Quantity += item.Quantity
+			continue
+		}
+
+		index[item.Product_ID] = len(lines)
+		lines = append(lines, item)
 	}
 
-	var productCart []models.ProductUser
-	err = searchFromDB.All(ctx, &productCart)
-	if err != nil {
-		log.Println(err)
-		return ErrProductDecodingFailed
-	}
+	return lines
+}
 
-	id, err := primitive.ObjectIDFromHex(userId)
+// upgradeCart stores a cart written before cart lines carried a quantity in
+// its normalized form, so the quantity filters of the write paths match its
+// lines, and never with a line missing its product id. Carts that are already
+// normalized are left untouched.
+func (s *mongoStore) upgradeCart(ctx context.Context, id primitive.ObjectID) error {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"usercart": 1, "cart_version": 1})
+	err := s.userCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return ErrUserNotFound
+	}
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdNotValid
+		return ErrCantGetItem
 	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "usercart", Value: bson.D{{Key: "$each", Value: productCart}}}}}}
+	lines := normalizeCart(user.UserCart)
+	legacy := len(lines) != len(user.UserCart)
+	for _, item := range user.UserCart {
+		if item.Quantity <= 0 {
+			legacy = true
+		}
+	}
+	if !legacy {
+		return nil
+	}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
-	if err != nil {
+	// a concurrent write bumps the version; whoever wins stored a cart the
+	// write paths can work with
+	filter := cartVersionFilter(user.Cart_Version)
+	filter["_id"] = id
+	update := bson.M{"$set": bson.M{"usercart": lines}, "$inc": bson.M{"cart_version": 1}}
+	if _, err := s.userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
 		return ErrCantUpdateUser
 	}
 
 	return nil
 }
 
-func RemoveCartItem(ctx context.Context, productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+// AddProductToCart adds quantity units of item to the user's cart, creating
+// the line if the product is not in the cart yet. A line never grows beyond
+// maxQuantity.
+func (s *mongoStore) AddProductToCart(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdNotValid
 	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.M{"pull": bson.M{"usercart": bson.M{"_id": productID}}}
-	_, err = productCollection.UpdateMany(ctx, filter, update)
-	if err != nil {
-		return ErrCantRemoveCartItem
+	if quantity > maxQuantity {
+		return ErrQuantityLimit
 	}
 
-	return nil
-}
+	if err := s.upgradeCart(ctx, id); err != nil {
+		return err
+	}
 
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userID string) error {
-	// fetch the cart of the user
-	// find the cart total
-	// create an order with the items
-	// added order to the user collection
-	// added items in the cart to order list
-	// empty up the cart
+	// the line may appear between our two attempts, so go round once more
+	for attempt := 0; attempt < 2; attempt++ {
+		filter := bson.M{"_id": id, "usercart": bson.M{"$elemMatch": bson.M{"_id": item.Product_ID, "quantity": bson.M{"$lte": maxQuantity - quantity}}}}
+		update := bson.M{"$inc": bson.M{"usercart.$.quantity": quantity, "cart_version": 1}}
+		result, err := s.userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateUser
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+
+		count, err := s.userCollection.CountDocuments(ctx, bson.M{"_id": id, "usercart._id": item.Product_ID})
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateUser
+		}
+		if count > 0 {
+			return ErrQuantityLimit
+		}
+
+		item.Quantity = quantity
+		filter = bson.M{"_id": id, "usercart._id": bson.M{"$ne": item.Product_ID}}
+		update = bson.M{"$push": bson.M{"usercart": item}, "$inc": bson.M{"cart_version": 1}}
+		result, err = s.userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateUser
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+
+		if count, _ := s.userCollection.CountDocuments(ctx, bson.M{"_id": id}); count == 0 {
+			return ErrUserNotFound
+		}
+	}
+
+	return ErrCantUpdateUser
+}
 
+// DecrementCartItem takes quantity units off a cart line, dropping the line
+// once nothing is left.
+func (s *mongoStore) DecrementCartItem(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdNotValid
 	}
 
-	var getCartItems models.User
-	var orderCart models.Order
-
-	orderCart.Order_ID = primitive.NewObjectID()
-	orderCart.Order_At = time.Now()
-	orderCart.Order_Cart = make([]models.ProductUser, 0)
-	orderCart.Payment_Method.COD = true
-
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key:"path", Value: "$usercart"}}}}
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$usercart.price"}}}}}}
+	if err := s.upgradeCart(ctx, id); err != nil {
+		return err
+	}
 
-	currentResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
+	filter := bson.M{"_id": id, "usercart": bson.M{"$elemMatch": bson.M{"_id": productID, "quantity": bson.M{"$gt": quantity}}}}
+	update := bson.M{"$inc": bson.M{"usercart.$.quantity": -quantity, "cart_version": 1}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return ErrCantRemoveCartItem
+	}
+	if result.MatchedCount == 1 {
+		return nil
 	}
 
-	var getUserCart []bson.M
-	if err = currentResults.All(ctx, &getUserCart); err != nil {
-		panic(err)
+	filter = bson.M{"_id": id, "usercart._id": productID}
+	update = bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}, "$inc": bson.M{"cart_version": 1}}
+	result, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantRemoveCartItem
+	}
+	if result.MatchedCount == 0 {
+		return ErrCartItemNotFound
 	}
 
-	var total_price int32
+	return nil
+}
 
-	for _, user_item := range getUserCart {
-		price := user_item["total"]
-		total_price = price.(int32)
+// SetCartItemQuantity sets a cart line to exactly quantity units, creating it
+// from item if needed. A quantity of zero removes the line.
+func (s *mongoStore) SetCartItemQuantity(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error {
+	if quantity == 0 {
+		return s.RemoveCartItem(ctx, item.Product_ID, userID)
 	}
 
-	orderCart.Price = int(total_price)
+	if quantity > maxQuantity {
+		return ErrQuantityLimit
+	}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
+		return ErrUserIdNotValid
 	}
-	err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getCartItems)
-	if err != nil {
-		log.Println(err)
+
+	if err := s.upgradeCart(ctx, id); err != nil {
+		return err
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push":bson.M{"orders.$[].order_list": bson.M{"$each": getCartItems.UserCart}}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	filter := bson.M{"_id": id, "usercart._id": item.Product_ID}
+	update := bson.M{"$set": bson.M{"usercart.$.quantity": quantity}, "$inc": bson.M{"cart_version": 1}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		log.Println(err)
+		return ErrCantUpdateUser
 	}
-
-	userCartEmpty := make([]models.ProductUser, 0)
-	filter3 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update3 := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usercart", Value: userCartEmpty}}}}
-	_, err = userCollection.UpdateOne(ctx, filter3, update3)
-	if err != nil {
-		return ErrCantBuyCartItem
+	if result.MatchedCount == 1 {
+		return nil
 	}
 
-	return nil
+	return s.AddProductToCart(ctx, userID, item, quantity, maxQuantity)
 }
 
-func InstantBuyer(ctx context.Context, productCollection, userCollection *mongo.Collection, productID primitive.ObjectID, userID string) error {
+func (s *mongoStore) RemoveCartItem(ctx context.Context, productID primitive.ObjectID, userID string) error {
 	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdNotValid
 	}
 
-	var productDetails models.ProductUser
-	var ordersDetail models.Order
-
-	ordersDetail.Order_ID = primitive.NewObjectID()
-	ordersDetail.Order_At = time.Now()
-	ordersDetail.Order_Cart = make([]models.ProductUser, 0)
-	ordersDetail.Payment_Method.COD = true
-
-	err = productCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&productDetails)
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.M{"$pull": bson.M{"usercart": bson.M{"_id": productID}}, "$inc": bson.M{"cart_version": 1}}
+	_, err = s.userCollection.UpdateMany(ctx, filter, update)
 	if err != nil {
-		log.Println(err)
+		return ErrCantRemoveCartItem
 	}
 
-	ordersDetail.Price = productDetails.Price
+	return nil
+}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: ordersDetail}}}}
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+func (s *mongoStore) GetCart(ctx context.Context, userID string) (*Cart, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		log.Println(err)
+		return nil, ErrUserIdNotValid
 	}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 :=bson.M{"$push": bson.M{"orders.$[].order_list": productDetails}}
-	_, err = userCollection.UpdateOne(ctx, filter2, update2)
+	var filledcart models.User
+	err = s.userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&filledcart)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
 	if err != nil {
 		log.Println(err)
+		return nil, ErrCantGetItem
 	}
 
-	return nil
+	return &Cart{Items: normalizeCart(filledcart.UserCart), Version: filledcart.Cart_Version}, nil
+}
+
+// cartVersionFilter matches the cart version read before checkout. Users
+// created before carts were versioned have no field at all, which reads as 0.
+func cartVersionFilter(version int) bson.M {
+	if version == 0 {
+		return bson.M{"cart_version": bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"cart_version": version}
 }
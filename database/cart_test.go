@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestNormalizeLegacyCart(t *testing.T) {
+	lamp, desk := primitive.NewObjectID(), primitive.NewObjectID()
+
+	// old carts keep their product ids under product_id,
+	// and before quantities every unit is its own line
+	data, err := bson.Marshal(bson.M{"usercart": bson.A{
+		bson.M{"product_id": lamp, "price": 1500},
+		bson.M{"product_id": desk, "price": 9000},
+		bson.M{"product_id": lamp, "price": 1500},
+		bson.M{"price": 100},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user models.User
+	if err := bson.Unmarshal(data, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := normalizeCart(user.UserCart)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %+v", len(lines), lines)
+	}
+	if lines[0].Product_ID != lamp || lines[0].Quantity != 2 {
+		t.Errorf("first line = %s x %d, want %s x 2", lines[0].Product_ID.Hex(), lines[0].Quantity, lamp.Hex())
+	}
+	if lines[1].Product_ID != desk || lines[1].Quantity != 1 {
+		t.Errorf("second line = %s x %d, want %s x 1", lines[1].Product_ID.Hex(), lines[1].Quantity, desk.Hex())
+	}
+}
@@ -2,61 +2,123 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/fredele20/e-commerce-cart/config"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 
-func DBSet() *mongo.Client {
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		log.Fatal(err)
-	}
+var maxConnectBackoff = 30 * time.Second
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
-	defer cancel()
+// Connect dials MongoDB and pings it until it answers, backing off
+// exponentially between attempts. The client is disconnected again if the
+// database never becomes reachable.
+func Connect(ctx context.Context, cfg config.Mongo) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(cfg.URI)
+	if cfg.Username != "" {
+		clientOptions.SetAuth(options.Credential{Username: cfg.Username, Password: cfg.Password})
+	}
 
-	err = client.Connect(ctx)
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("invalid mongodb settings: %w", err)
 	}
 
-	err = client.Ping(context.TODO(), nil)
-	if err != nil {
-		log.Println("failed to connect to mongodb:")
-		return nil
+	if err = client.Connect(ctx); err != nil {
+		return nil, fmt.Errorf("cannot start mongodb client: %w", err)
 	}
 
-	fmt.Println("connection to the database established")
+	backoff := cfg.ConnectBackoff.Std()
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout.Std())
+		err = client.Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			log.Println("connection to the database established")
+			return client, nil
+		}
+
+		if attempt >= cfg.ConnectAttempts {
+			break
+		}
 
-	return client
+		log.Printf("mongodb not reachable (attempt %d of %d), retrying in %s: %v", attempt, cfg.ConnectAttempts, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			_ = client.Disconnect(context.Background())
+			return nil, fmt.Errorf("gave up connecting to mongodb: %w", ctx.Err())
+		}
+
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+
+	_ = client.Disconnect(context.Background())
+	return nil, fmt.Errorf("mongodb unreachable after %d attempts: %w", cfg.ConnectAttempts, err)
 }
 
-var Client *mongo.Client = DBSet()
+// CheckTransactions fails unless the server supports multi-document
+// transactions, which checkout relies on. A standalone mongod does not; it
+// has to run as a (single node) replica set.
+func CheckTransactions(ctx context.Context, client *mongo.Client) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("cannot ask mongodb for its topology: %w", err)
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return errors.New("mongodb must run as a replica set or sharded cluster, checkout needs transactions")
+	}
+	return nil
+}
 
-func UserData(client *mongo.Client, collectionName string) *mongo.Collection {
-	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
-	return collection
+type mongoStore struct {
+	userCollection    *mongo.Collection
+	productCollection *mongo.Collection
+	orderCollection   *mongo.Collection
+	keyCollection     *mongo.Collection
 }
 
-func ProductData(client *mongo.Client, collectionName string) *mongo.Collection {
-	var productCollection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
-	return productCollection
+func NewMongoStore(client *mongo.Client, dbName string) *Store {
+	db := client.Database(dbName)
+	store := &mongoStore{
+		userCollection:    db.Collection("Users"),
+		productCollection: db.Collection("Products"),
+		orderCollection:   db.Collection(ordersCollection),
+		keyCollection:     db.Collection(idempotencyCollection),
+	}
+
+	return &Store{Users: store, Products: store, Carts: store, Orders: store, Inventory: store, Idempotency: store}
 }
 
-var userCollection *mongo.Collection = UserData(Client, "Users")
+// CreateIndexes makes sure the indexes the store relies on exist. Creating an
+// index that is already there is a no-op, so it runs on every start.
+func CreateIndexes(ctx context.Context, client *mongo.Client, dbName string) error {
+	db := client.Database(dbName)
+	indexes := []struct {
+		collection string
+		models     []mongo.IndexModel
+	}{
+		{ordersCollection, orderIndexes},
+		{idempotencyCollection, idempotencyIndexes},
+	}
 
-func CheckUserAlreadyExist(ctx context.Context, field, value string) (count int64, err error) {
-	count, err = userCollection.CountDocuments(ctx, bson.M{field: value})
-	if err != nil {
-		log.Panic(err)
-		fmt.Printf("Error checking for %v", field)
-		return count, err
+	for _, index := range indexes {
+		if _, err := db.Collection(index.collection).Indexes().CreateMany(ctx, index.models); err != nil {
+			return fmt.Errorf("%s: %w", index.collection, err)
+		}
 	}
-	return count, err
+
+	return nil
 }
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const idempotencyCollection = "IdempotencyKeys"
+
+var ErrCantReserveKey = errors.New("cannot reserve the idempotency key")
+
+// idempotencyIndexes make a key unique per user and let MongoDB drop records
+// once they expire.
+var idempotencyIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+}
+
+func (s *mongoStore) ReserveKey(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	filter := bson.M{"user_id": record.User_ID, "key": record.Key}
+
+	// an expired record may linger until MongoDB removes it; it is deleted
+	// here and the claim tried once more
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := s.keyCollection.InsertOne(ctx, record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			log.Println(err)
+			return nil, ErrCantReserveKey
+		}
+
+		var existing models.IdempotencyRecord
+		err = s.keyCollection.FindOne(ctx, filter).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			return nil, ErrCantReserveKey
+		}
+
+		if existing.Expires_At.After(record.Created_At) {
+			return &existing, nil
+		}
+
+		expired := bson.M{"user_id": record.User_ID, "key": record.Key, "expires_at": bson.M{"$lte": record.Created_At}}
+		if _, err := s.keyCollection.DeleteOne(ctx, expired); err != nil {
+			log.Println(err)
+			return nil, ErrCantReserveKey
+		}
+	}
+
+	return nil, ErrCantReserveKey
+}
+
+func (s *mongoStore) CompleteKey(ctx context.Context, userID, key string, status int, contentType string, body []byte) error {
+	filter := bson.M{"user_id": userID, "key": key}
+	update := bson.M{"$set": bson.M{
+		"completed":    true,
+		"status":       status,
+		"content_type": contentType,
+		"body":         body,
+	}}
+
+	if _, err := s.keyCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantReserveKey
+	}
+
+	return nil
+}
+
+func (s *mongoStore) ReleaseKey(ctx context.Context, userID, key string) error {
+	filter := bson.M{"user_id": userID, "key": key, "completed": false}
+	if _, err := s.keyCollection.DeleteOne(ctx, filter); err != nil {
+		log.Println(err)
+		return ErrCantReserveKey
+	}
+
+	return nil
+}
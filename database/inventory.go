@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrInsufficientStock = errors.New("not enough stock for this product")
+	ErrCantUpdateStock   = errors.New("cannot update the stock of this product")
+)
+
+// moveStock replaces the user's hold on a product with hold (nil drops it)
+// and takes take units off the stock, negative amounts returning them. The
+// update only applies if the stock and the hold are still what was read, so
+// it is retried once when another request got there first.
+func (s *mongoStore) moveStock(ctx context.Context, productID primitive.ObjectID, userID string, change func(held int) (take int, hold *models.Reservation)) error {
+	for attempt := 0; attempt < 2; attempt++ {
+		var product models.Product
+		err := s.productCollection.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
+		if err == mongo.ErrNoDocuments {
+			return ErrProductNotFound
+		}
+		if err != nil {
+			log.Println(err)
+			return ErrProductDecodingFailed
+		}
+
+		if product.Stock == nil {
+			return nil
+		}
+
+		held, found := product.Reserved(userID)
+		take, hold := change(held)
+		if take > *product.Stock {
+			return ErrInsufficientStock
+		}
+		if take == 0 && hold == nil && !found {
+			return nil
+		}
+
+		filter := bson.M{"_id": productID, "stock": bson.M{"$gte": take}}
+		if found {
+			filter["reservations"] = bson.M{"$elemMatch": bson.M{"user_id": userID, "quantity": held}}
+		} else {
+			filter["reservations.user_id"] = bson.M{"$ne": userID}
+		}
+
+		update := bson.M{"$inc": bson.M{"stock": -take}}
+		switch {
+		case hold == nil && found:
+			update["$pull"] = bson.M{"reservations": bson.M{"user_id": userID}}
+		case hold != nil && found:
+			update["$set"] = bson.M{"reservations.$": hold}
+		case hold != nil:
+			update["$push"] = bson.M{"reservations": hold}
+		}
+
+		result, err := s.productCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			log.Println(err)
+			return ErrCantUpdateStock
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+	}
+
+	return ErrCantUpdateStock
+}
+
+func (s *mongoStore) ReserveStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int, expiresAt time.Time) error {
+	return s.moveStock(ctx, productID, userID, func(held int) (int, *models.Reservation) {
+		if quantity == 0 {
+			return -held, nil
+		}
+		return quantity - held, &models.Reservation{User_ID: userID, Quantity: quantity, Expires_At: expiresAt}
+	})
+}
+
+// commitHold is the stock change of a sale of quantity units: the user's hold
+// is consumed and the rest is taken from the stock.
+func commitHold(quantity int) func(held int) (int, *models.Reservation) {
+	return func(held int) (int, *models.Reservation) {
+		return quantity - held, nil
+	}
+}
+
+func (s *mongoStore) CommitStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error {
+	return s.moveStock(ctx, productID, userID, commitHold(quantity))
+}
+
+func (s *mongoStore) RestoreStock(ctx context.Context, productID primitive.ObjectID, quantity int) error {
+	filter := bson.M{"_id": productID, "stock": bson.M{"$exists": true}}
+	_, err := s.productCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": quantity}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateStock
+	}
+
+	return nil
+}
+
+func (s *mongoStore) ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	expired := bson.M{"$elemMatch": bson.M{"expires_at": bson.M{"$lte": now}}}
+	cursor, err := s.productCollection.Find(ctx, bson.M{"reservations": expired})
+	if err != nil {
+		log.Println(err)
+		return 0, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	var products []models.Product
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return 0, ErrProductDecodingFailed
+	}
+
+	released := 0
+	for _, product := range products {
+		for _, reservation := range product.Reservations {
+			if reservation.Expires_At.After(now) {
+				continue
+			}
+
+			// matching the whole hold leaves it alone if it was renewed or
+			// changed since we read it
+			filter := bson.M{"_id": product.Product_ID, "reservations": bson.M{"$elemMatch": bson.M{
+				"user_id":    reservation.User_ID,
+				"quantity":   reservation.Quantity,
+				"expires_at": bson.M{"$lte": now},
+			}}}
+			update := bson.M{
+				"$pull": bson.M{"reservations": bson.M{"user_id": reservation.User_ID}},
+				"$inc":  bson.M{"stock": reservation.Quantity},
+			}
+			result, err := s.productCollection.UpdateOne(ctx, filter, update)
+			if err != nil {
+				log.Println(err)
+				return released, ErrCantUpdateStock
+			}
+			released += int(result.ModifiedCount)
+		}
+	}
+
+	return released, nil
+}
@@ -0,0 +1,797 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryStore keeps every repository in process memory. It mirrors the
+// behaviour of the mongo store so the API can run and be exercised without a
+// database; nothing survives a restart.
+type memoryStore struct {
+	mu       sync.Mutex
+	users    map[string]*models.User
+	products map[primitive.ObjectID]models.Product
+	orders   map[primitive.ObjectID]*models.Order
+	keys     map[string]*models.IdempotencyRecord
+}
+
+func NewMemoryStore() *Store {
+	store := &memoryStore{
+		users:    make(map[string]*models.User),
+		products: make(map[primitive.ObjectID]models.Product),
+		orders:   make(map[primitive.ObjectID]*models.Order),
+		keys:     make(map[string]*models.IdempotencyRecord),
+	}
+
+	return &Store{Users: store, Products: store, Carts: store, Orders: store, Inventory: store, Idempotency: store}
+}
+
+func cloneUser(user *models.User) *models.User {
+	clone := *user
+	clone.UserCart = append([]models.ProductUser(nil), user.UserCart...)
+	clone.Address_Details = append([]models.Address(nil), user.Address_Details...)
+	clone.Sessions = append([]models.Session(nil), user.Sessions...)
+	return &clone
+}
+
+func cloneProduct(product models.Product) models.Product {
+	if product.Stock != nil {
+		stock := *product.Stock
+		product.Stock = &stock
+	}
+	product.Reservations = append([]models.Reservation(nil), product.Reservations...)
+	return product
+}
+
+// user must be called with mu held.
+func (s *memoryStore) user(userID string) (*models.User, error) {
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (s *memoryStore) CreateUser(ctx context.Context, user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.User_ID]; ok {
+		return ErrCantCreateUser
+	}
+
+	s.users[user.User_ID] = cloneUser(&user)
+	return nil
+}
+
+func (s *memoryStore) FindUserByID(ctx context.Context, userID string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+	return cloneUser(user), nil
+}
+
+func (s *memoryStore) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email != nil && *user.Email == email {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (s *memoryStore) CheckUserAlreadyExist(ctx context.Context, field, value string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, user := range s.users {
+		var got *string
+		switch field {
+		case "email":
+			got = user.Email
+		case "phone":
+			got = user.Phone
+		}
+		if got != nil && *got == value {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memoryStore) UpdateAllTokens(ctx context.Context, userID, signedToken, signedRefreshToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	user.Token = &signedToken
+	user.Referesh_Token = &signedRefreshToken
+	user.Updated_At = time.Now()
+	return nil
+}
+
+func (s *memoryStore) SetUserRole(ctx context.Context, userID, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	user.Role = role
+	return nil
+}
+
+func (s *memoryStore) AddSession(ctx context.Context, userID string, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	sessions := make([]models.Session, 0, len(user.Sessions)+1)
+	for _, existing := range user.Sessions {
+		if existing.Expires_At.After(now) {
+			sessions = append(sessions, existing)
+		}
+	}
+	user.Sessions = append(sessions, session)
+	return nil
+}
+
+func (s *memoryStore) RotateSession(ctx context.Context, userID, oldRefreshID string, session models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+
+	for i, existing := range user.Sessions {
+		if existing.Session_ID != session.Session_ID {
+			continue
+		}
+
+		if existing.Refresh_ID != oldRefreshID || !existing.Expires_At.After(time.Now()) {
+			user.Sessions = append(user.Sessions[:i], user.Sessions[i+1:]...)
+			return ErrRefreshReused
+		}
+
+		user.Sessions[i].Refresh_ID = session.Refresh_ID
+		user.Sessions[i].Expires_At = session.Expires_At
+		return nil
+	}
+
+	return ErrSessionNotFound
+}
+
+func (s *memoryStore) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return nil
+	}
+
+	for i, existing := range user.Sessions {
+		if existing.Session_ID == sessionID {
+			user.Sessions = append(user.Sessions[:i], user.Sessions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) RevokeAllSessions(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	user.Sessions = make([]models.Session, 0)
+	user.Token_Version++
+	return nil
+}
+
+func (s *memoryStore) SessionActive(ctx context.Context, userID, sessionID string, tokenVersion int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil || user.Token_Version != tokenVersion {
+		return false, nil
+	}
+
+	for _, existing := range user.Sessions {
+		if existing.Session_ID == sessionID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) ListAddresses(ctx context.Context, userID string) ([]models.Address, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user.Addresses(), nil
+}
+
+// setDefaults must be called with mu held. It makes address the user's
+// default as its flags say and stops it being the default otherwise.
+func setDefaults(user *models.User, address models.Address) {
+	if address.Default_Shipping {
+		user.Default_Shipping = address.Address_ID
+	} else if user.Default_Shipping == address.Address_ID {
+		user.Default_Shipping = primitive.NilObjectID
+	}
+
+	if address.Default_Billing {
+		user.Default_Billing = address.Address_ID
+	} else if user.Default_Billing == address.Address_ID {
+		user.Default_Billing = primitive.NilObjectID
+	}
+}
+
+func (s *memoryStore) AddAddress(ctx context.Context, userID string, address models.Address, limit int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	if len(user.Address_Details) >= limit {
+		return ErrAddressLimit
+	}
+
+	user.Address_Details = append(user.Address_Details, address)
+	setDefaults(user, address)
+	if user.Default_Shipping.IsZero() {
+		user.Default_Shipping = address.Address_ID
+	}
+	if user.Default_Billing.IsZero() {
+		user.Default_Billing = address.Address_ID
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateAddress(ctx context.Context, userID string, address models.Address) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	for i := range user.Address_Details {
+		if user.Address_Details[i].Address_ID == address.Address_ID {
+			user.Address_Details[i] = address
+			setDefaults(user, address)
+			return nil
+		}
+	}
+	return ErrAddressNotFound
+}
+
+func (s *memoryStore) DeleteAddress(ctx context.Context, userID string, addressID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return err
+	}
+
+	for i, address := range user.Address_Details {
+		if address.Address_ID == addressID {
+			user.Address_Details = append(user.Address_Details[:i:i], user.Address_Details[i+1:]...)
+			setDefaults(user, models.Address{Address_ID: addressID})
+			return nil
+		}
+	}
+	return ErrAddressNotFound
+}
+
+func (s *memoryStore) AddProduct(ctx context.Context, product models.Product) (*models.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product.Product_ID = primitive.NewObjectID()
+	product.Reservations = nil
+	s.products[product.Product_ID] = cloneProduct(product)
+	return &product, nil
+}
+
+func (s *memoryStore) GetProduct(ctx context.Context, productID primitive.ObjectID) (*models.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	product = cloneProduct(product)
+	return &product, nil
+}
+
+func (s *memoryStore) ListProducts(ctx context.Context) ([]models.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	products := make([]models.Product, 0, len(s.products))
+	for _, product := range s.products {
+		products = append(products, cloneProduct(product))
+	}
+	return products, nil
+}
+
+func (s *memoryStore) SearchProducts(ctx context.Context, name string) ([]models.Product, error) {
+	pattern, err := regexp.Compile(name)
+	if err != nil {
+		return nil, ErrCantListProducts
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	products := make([]models.Product, 0)
+	for _, product := range s.products {
+		if product.Product_Name != nil && pattern.MatchString(*product.Product_Name) {
+			products = append(products, cloneProduct(product))
+		}
+	}
+	return products, nil
+}
+
+func (s *memoryStore) UpdateProduct(ctx context.Context, productID primitive.ObjectID, product models.Product) (*models.Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.products[productID]
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+
+	product.Product_ID = productID
+	if product.Stock != nil {
+		product.Reservations = existing.Reservations
+	}
+	s.products[productID] = cloneProduct(product)
+	product.Reservations = nil
+	return &product, nil
+}
+
+func (s *memoryStore) DeleteProduct(ctx context.Context, productID primitive.ObjectID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.products[productID]; !ok {
+		return ErrProductNotFound
+	}
+
+	delete(s.products, productID)
+	return nil
+}
+
+// cartLine must be called with mu held.
+func cartLine(user *models.User, productID primitive.ObjectID) int {
+	for i, item := range user.UserCart {
+		if item.Product_ID == productID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *memoryStore) AddProductToCart(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return ErrUserIdNotValid
+	}
+
+	if i := cartLine(user, item.Product_ID); i >= 0 {
+		if user.UserCart[i].Quantity+quantity > maxQuantity {
+			return ErrQuantityLimit
+		}
+		user.UserCart[i].Quantity += quantity
+	} else {
+		if quantity > maxQuantity {
+			return ErrQuantityLimit
+		}
+		item.Quantity = quantity
+		user.UserCart = append(user.UserCart, item)
+	}
+
+	user.Cart_Version++
+	return nil
+}
+
+func (s *memoryStore) DecrementCartItem(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return ErrUserIdNotValid
+	}
+
+	i := cartLine(user, productID)
+	if i < 0 {
+		return ErrCartItemNotFound
+	}
+
+	if user.UserCart[i].Quantity > quantity {
+		user.UserCart[i].Quantity -= quantity
+	} else {
+		user.UserCart = append(user.UserCart[:i], user.UserCart[i+1:]...)
+	}
+
+	user.Cart_Version++
+	return nil
+}
+
+func (s *memoryStore) SetCartItemQuantity(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error {
+	if quantity == 0 {
+		return s.RemoveCartItem(ctx, item.Product_ID, userID)
+	}
+
+	if quantity > maxQuantity {
+		return ErrQuantityLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return ErrUserIdNotValid
+	}
+
+	if i := cartLine(user, item.Product_ID); i >= 0 {
+		user.UserCart[i].Quantity = quantity
+	} else {
+		item.Quantity = quantity
+		user.UserCart = append(user.UserCart, item)
+	}
+
+	user.Cart_Version++
+	return nil
+}
+
+func (s *memoryStore) RemoveCartItem(ctx context.Context, productID primitive.ObjectID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return ErrUserIdNotValid
+	}
+
+	cart := make([]models.ProductUser, 0, len(user.UserCart))
+	for _, item := range user.UserCart {
+		if item.Product_ID != productID {
+			cart = append(cart, item)
+		}
+	}
+	user.UserCart = cart
+	user.Cart_Version++
+	return nil
+}
+
+func (s *memoryStore) GetCart(ctx context.Context, userID string) (*Cart, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cart{Items: normalizeCart(user.UserCart), Version: user.Cart_Version}, nil
+}
+
+func (s *memoryStore) BuyItemFromCart(ctx context.Context, userID string, order models.Order, cartVersion int) (*models.ProductUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.user(userID)
+	if err != nil {
+		return nil, ErrUserIdNotValid
+	}
+
+	if user.Cart_Version != cartVersion {
+		return nil, ErrCartChanged
+	}
+
+	// moveStock replaces the products it changes, so putting the old ones
+	// back undoes the lines already taken
+	taken := make(map[primitive.ObjectID]models.Product)
+	for i, item := range order.Order_Cart {
+		if product, ok := s.products[item.Product_ID]; ok {
+			if _, seen := taken[item.Product_ID]; !seen {
+				taken[item.Product_ID] = product
+			}
+		}
+		if err := s.moveStock(item.Product_ID, userID, commitHold(item.Quantity)); err != nil {
+			for id, product := range taken {
+				s.products[id] = product
+			}
+			return &order.Order_Cart[i], err
+		}
+	}
+
+	order.User_ID = userID
+	s.orders[order.Order_ID] = cloneOrder(&order)
+	user.UserCart = make([]models.ProductUser, 0)
+	user.Cart_Version++
+	return nil, nil
+}
+
+func (s *memoryStore) InstantBuyer(ctx context.Context, userID string, order models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.user(userID); err != nil {
+		return ErrUserIdNotValid
+	}
+
+	order.User_ID = userID
+	s.orders[order.Order_ID] = cloneOrder(&order)
+	return nil
+}
+
+// moveStock must be called with mu held. It mirrors mongoStore.moveStock.
+func (s *memoryStore) moveStock(productID primitive.ObjectID, userID string, change func(held int) (take int, hold *models.Reservation)) error {
+	product, ok := s.products[productID]
+	if !ok {
+		return ErrProductNotFound
+	}
+
+	if product.Stock == nil {
+		return nil
+	}
+
+	held, _ := product.Reserved(userID)
+	take, hold := change(held)
+	if take > *product.Stock {
+		return ErrInsufficientStock
+	}
+
+	product = cloneProduct(product)
+	*product.Stock -= take
+
+	reservations := make([]models.Reservation, 0, len(product.Reservations)+1)
+	for _, reservation := range product.Reservations {
+		if reservation.User_ID != userID {
+			reservations = append(reservations, reservation)
+		}
+	}
+	if hold != nil {
+		reservations = append(reservations, *hold)
+	}
+	product.Reservations = reservations
+
+	s.products[productID] = product
+	return nil
+}
+
+func (s *memoryStore) ReserveStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.moveStock(productID, userID, func(held int) (int, *models.Reservation) {
+		if quantity == 0 {
+			return -held, nil
+		}
+		return quantity - held, &models.Reservation{User_ID: userID, Quantity: quantity, Expires_At: expiresAt}
+	})
+}
+
+func (s *memoryStore) CommitStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.moveStock(productID, userID, commitHold(quantity))
+}
+
+func (s *memoryStore) RestoreStock(ctx context.Context, productID primitive.ObjectID, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productID]
+	if !ok || product.Stock == nil {
+		return nil
+	}
+
+	product = cloneProduct(product)
+	*product.Stock += quantity
+	s.products[productID] = product
+	return nil
+}
+
+func (s *memoryStore) ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	released := 0
+	for id, product := range s.products {
+		if product.Stock == nil || len(product.Reservations) == 0 {
+			continue
+		}
+
+		product = cloneProduct(product)
+		active := make([]models.Reservation, 0, len(product.Reservations))
+		for _, reservation := range product.Reservations {
+			if reservation.Expires_At.After(now) {
+				active = append(active, reservation)
+				continue
+			}
+			*product.Stock += reservation.Quantity
+			released++
+		}
+		product.Reservations = active
+		s.products[id] = product
+	}
+
+	return released, nil
+}
+
+// order must be called with mu held.
+func (s *memoryStore) order(orderID primitive.ObjectID) (*models.Order, error) {
+	order, ok := s.orders[orderID]
+	if !ok {
+		return nil, ErrOrderNotFound
+	}
+	return order, nil
+}
+
+func cloneOrder(order *models.Order) *models.Order {
+	clone := *order
+	clone.Order_Cart = append([]models.ProductUser(nil), order.Order_Cart...)
+	clone.Status_History = append([]models.StatusChange(nil), order.Status_History...)
+	return &clone
+}
+
+func (s *memoryStore) GetOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.order(orderID)
+	if err != nil {
+		return nil, err
+	}
+	return cloneOrder(order), nil
+}
+
+func (s *memoryStore) ListUserOrders(ctx context.Context, userID string, skip, limit int) ([]models.Order, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var matching []*models.Order
+	for _, order := range s.orders {
+		if order.User_ID == userID {
+			matching = append(matching, order)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].Order_At.After(matching[j].Order_At)
+	})
+
+	orders := make([]models.Order, 0, limit)
+	for i := skip; i < len(matching) && i < skip+limit; i++ {
+		orders = append(orders, *cloneOrder(matching[i]))
+	}
+	return orders, int64(len(matching)), nil
+}
+
+func (s *memoryStore) UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from string, change models.StatusChange) (*models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.order(orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.CurrentStatus() != from {
+		return nil, ErrOrderStatusChanged
+	}
+
+	order.Status = change.Status
+	order.Status_History = append(order.Status_History, change)
+	return cloneOrder(order), nil
+}
+
+func (s *memoryStore) SetOrderPayment(ctx context.Context, orderID primitive.ObjectID, payment models.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, err := s.order(orderID)
+	if err != nil {
+		return err
+	}
+
+	order.Payment_Method = payment
+	return nil
+}
+
+func idempotencyKey(userID, key string) string {
+	return userID + "\x00" + key
+}
+
+func (s *memoryStore) ReserveKey(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey(record.User_ID, record.Key)
+	if existing, ok := s.keys[id]; ok && existing.Expires_At.After(record.Created_At) {
+		clone := *existing
+		return &clone, nil
+	}
+
+	s.keys[id] = &record
+	return nil, nil
+}
+
+func (s *memoryStore) CompleteKey(ctx context.Context, userID, key string, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.keys[idempotencyKey(userID, key)]; ok {
+		record.Completed = true
+		record.Status = status
+		record.Content_Type = contentType
+		record.Body = append([]byte(nil), body...)
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseKey(ctx context.Context, userID, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := idempotencyKey(userID, key)
+	if record, ok := s.keys[id]; ok && !record.Completed {
+		delete(s.keys, id)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const ordersCollection = "Orders"
+
+var (
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrOrderStatusChanged = errors.New("the order status changed in the meantime, please reload it and try again")
+	ErrCantUpdateOrder    = errors.New("cannot update this order")
+	ErrCantListOrders     = errors.New("cannot list orders")
+)
+
+// orderIndexes back looking orders up per user, per status and by date.
+var orderIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "order_at", Value: -1}}},
+	{Keys: bson.D{{Key: "status", Value: 1}, {Key: "order_at", Value: -1}}},
+	{Keys: bson.D{{Key: "order_at", Value: -1}}},
+}
+
+// orderStatusFilter matches an order status. Orders placed before statuses
+// were tracked have none, which reads as pending.
+func orderStatusFilter(status string) interface{} {
+	if status == models.OrderPending {
+		return bson.M{"$in": bson.A{models.OrderPending, nil}}
+	}
+	return status
+}
+
+// BuyItemFromCart takes the stock of the order lines, stores the order built
+// from the user's cart and empties the cart, guarded by the cart version, in
+// one transaction: either every write happens or none does. Transactions need
+// MongoDB to run as a replica set.
+func (s *mongoStore) BuyItemFromCart(ctx context.Context, userID string, order models.Order, cartVersion int) (*models.ProductUser, error) {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdNotValid
+	}
+
+	order.User_ID = userID
+	filter := bson.M{"$and": bson.A{bson.M{"_id": id}, cartVersionFilter(cartVersion)}}
+	update := bson.M{
+		"$set": bson.M{"usercart": make([]models.ProductUser, 0)},
+		"$inc": bson.M{"cart_version": 1},
+	}
+
+	session, err := s.orderCollection.Database().Client().StartSession()
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantBuyCartItem
+	}
+	defer session.EndSession(ctx)
+
+	var failed *models.ProductUser
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		failed = nil
+
+		result, err := s.userCollection.UpdateOne(sc, filter, update)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, ErrCartChanged
+		}
+
+		for i, item := range order.Order_Cart {
+			if err := s.moveStock(sc, item.Product_ID, userID, commitHold(item.Quantity)); err != nil {
+				failed = &order.Order_Cart[i]
+				return nil, err
+			}
+		}
+
+		_, err = s.orderCollection.InsertOne(sc, order)
+		return nil, err
+	})
+	switch err {
+	case nil:
+		return nil, nil
+	case ErrCartChanged:
+		return nil, err
+	case ErrInsufficientStock, ErrProductNotFound, ErrCantUpdateStock:
+		return failed, err
+	}
+
+	log.Println(err)
+	return nil, ErrCantBuyCartItem
+}
+
+func (s *mongoStore) InstantBuyer(ctx context.Context, userID string, order models.Order) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdNotValid
+	}
+
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		log.Println(err)
+		return ErrCantBuyCartItem
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+
+	order.User_ID = userID
+	if _, err = s.orderCollection.InsertOne(ctx, order); err != nil {
+		log.Println(err)
+		return ErrCantBuyCartItem
+	}
+
+	return nil
+}
+
+func (s *mongoStore) GetOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Order, error) {
+	var order models.Order
+	err := s.orderCollection.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrOrderNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantGetItem
+	}
+
+	return &order, nil
+}
+
+func (s *mongoStore) ListUserOrders(ctx context.Context, userID string, skip, limit int) ([]models.Order, int64, error) {
+	filter := bson.M{"user_id": userID}
+
+	total, err := s.orderCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "order_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := s.orderCollection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+	defer cursor.Close(ctx)
+
+	orders := make([]models.Order, 0)
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return nil, 0, ErrCantListOrders
+	}
+
+	return orders, total, nil
+}
+
+func (s *mongoStore) UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from string, change models.StatusChange) (*models.Order, error) {
+	filter := bson.M{"_id": orderID, "status": orderStatusFilter(from)}
+	update := bson.M{
+		"$set":  bson.M{"status": change.Status},
+		"$push": bson.M{"status_history": change},
+	}
+
+	var order models.Order
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.orderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		if _, err := s.GetOrder(ctx, orderID); err != nil {
+			return nil, err
+		}
+		return nil, ErrOrderStatusChanged
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateOrder
+	}
+
+	return &order, nil
+}
+
+func (s *mongoStore) SetOrderPayment(ctx context.Context, orderID primitive.ObjectID, payment models.Payment) error {
+	result, err := s.orderCollection.UpdateOne(ctx, bson.M{"_id": orderID}, bson.M{"$set": bson.M{"payment_method": payment}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateOrder
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrOrderNotFound
+	}
+
+	return nil
+}
+
+// MigrateEmbeddedOrders moves the orders still embedded in user documents
+// into the orders collection and reports how many it moved. Orders are
+// copied before they are pulled from their user, so the migration can be
+// interrupted and run again safely.
+func MigrateEmbeddedOrders(ctx context.Context, client *mongo.Client, dbName string) (int, error) {
+	db := client.Database(dbName)
+	users := db.Collection("Users")
+	orders := db.Collection(ordersCollection)
+
+	cursor, err := users.Find(ctx, bson.M{"orders.0": bson.M{"$exists": true}}, options.Find().SetProjection(bson.M{"orders": 1}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	moved := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			return moved, err
+		}
+
+		ids := make(bson.A, 0, len(user.Order_Status))
+		for _, order := range user.Order_Status {
+			order.User_ID = user.ID.Hex()
+			if order.Status == "" {
+				order.Status = models.OrderPending
+				order.Status_History = []models.StatusChange{{Status: models.OrderPending, At: order.Order_At}}
+			}
+
+			// an order copied by an interrupted run may have moved on since,
+			// so the copy in the collection wins
+			if _, err := orders.InsertOne(ctx, order); err != nil && !mongo.IsDuplicateKeyError(err) {
+				return moved, err
+			}
+			ids = append(ids, order.Order_ID)
+		}
+
+		update := bson.M{"$pull": bson.M{"orders": bson.M{"_id": bson.M{"$in": ids}}}}
+		if _, err := users.UpdateOne(ctx, bson.M{"_id": user.ID}, update); err != nil {
+			return moved, err
+		}
+		moved += len(ids)
+	}
+
+	return moved, cursor.Err()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBuyItemFromCartTakesAllTheStockOrNone(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	userID := primitive.NewObjectID().Hex()
+	if err := store.Users.CreateUser(ctx, models.User{User_ID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	stock := func(units int) *int { return &units }
+	lamp, err := store.Products.AddProduct(ctx, models.Product{Stock: stock(5)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desk, err := store.Products.AddProduct(ctx, models.Product{Stock: stock(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Inventory.ReserveStock(ctx, lamp.Product_ID, userID, 2, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []struct {
+		product  *models.Product
+		quantity int
+	}{{lamp, 2}, {desk, 3}} {
+		if err := store.Carts.AddProductToCart(ctx, userID, models.NewProductUser(*item.product), item.quantity, 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cart, err := store.Carts.GetCart(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{Order_ID: primitive.NewObjectID(), Order_Cart: cart.Items}
+
+	// the lines and the holds are left alone whatever stops the order
+	if _, err := store.Orders.BuyItemFromCart(ctx, userID, order, cart.Version-1); err != ErrCartChanged {
+		t.Fatalf("stale cart: err = %v, want %v", err, ErrCartChanged)
+	}
+	failed, err := store.Orders.BuyItemFromCart(ctx, userID, order, cart.Version)
+	if err != ErrInsufficientStock || failed == nil || failed.Product_ID != desk.Product_ID {
+		t.Fatalf("short line: failed = %+v, err = %v, want the desk and %v", failed, err, ErrInsufficientStock)
+	}
+
+	found, err := store.Products.GetProduct(ctx, lamp.Product_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held, _ := found.Reserved(userID); *found.Stock != 3 || held != 2 {
+		t.Errorf("lamp stock = %d with %d held, want 3 with 2 held", *found.Stock, held)
+	}
+	if _, err := store.Orders.GetOrder(ctx, order.Order_ID); err != ErrOrderNotFound {
+		t.Errorf("order lookup err = %v, want %v", err, ErrOrderNotFound)
+	}
+	if cart, err := store.Carts.GetCart(ctx, userID); err != nil || len(cart.Items) != 2 {
+		t.Errorf("cart = %+v (%v), want both lines kept", cart, err)
+	}
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrCantAddProduct    = errors.New("cannot add this product")
+	ErrCantUpdateProduct = errors.New("cannot update this product")
+	ErrCantDeleteProduct = errors.New("cannot delete this product")
+	ErrCantListProducts  = errors.New("cannot list products")
+)
+
+func (s *mongoStore) AddProduct(ctx context.Context, product models.Product) (*models.Product, error) {
+	product.Product_ID = primitive.NewObjectID()
+
+	_, err := s.productCollection.InsertOne(ctx, product)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantAddProduct
+	}
+
+	return &product, nil
+}
+
+func (s *mongoStore) GetProduct(ctx context.Context, productID primitive.ObjectID) (*models.Product, error) {
+	var product models.Product
+	err := s.productCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}}).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrProductDecodingFailed
+	}
+
+	return &product, nil
+}
+
+func (s *mongoStore) ListProducts(ctx context.Context) ([]models.Product, error) {
+	cursor, err := s.productCollection.Find(ctx, bson.D{})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrProductDecodingFailed
+	}
+
+	return products, nil
+}
+
+func (s *mongoStore) SearchProducts(ctx context.Context, name string) ([]models.Product, error) {
+	cursor, err := s.productCollection.Find(ctx, bson.M{"product_name": bson.M{"$regex": name}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantListProducts
+	}
+	defer cursor.Close(ctx)
+
+	products := make([]models.Product, 0)
+	if err = cursor.All(ctx, &products); err != nil {
+		log.Println(err)
+		return nil, ErrProductDecodingFailed
+	}
+
+	return products, nil
+}
+
+func (s *mongoStore) UpdateProduct(ctx context.Context, productID primitive.ObjectID, product models.Product) (*models.Product, error) {
+	filter := bson.D{primitive.E{Key: "_id", Value: productID}}
+	set := bson.D{
+		{Key: "product_name", Value: product.Product_Name},
+		{Key: "price", Value: product.Price},
+		{Key: "rating", Value: product.Rating},
+		{Key: "image", Value: product.Image},
+		{Key: "max_quantity", Value: product.Max_Quantity},
+		{Key: "weight", Value: product.Weight},
+		{Key: "category", Value: product.Category},
+	}
+	update := bson.D{}
+	if product.Stock != nil {
+		set = append(set, primitive.E{Key: "stock", Value: *product.Stock})
+	} else {
+		// without a stock the product is no longer tracked, nor are its holds
+		update = append(update, primitive.E{Key: "$unset", Value: bson.D{{Key: "stock", Value: ""}, {Key: "reservations", Value: ""}}})
+	}
+	update = append(update, primitive.E{Key: "$set", Value: set})
+
+	var updated models.Product
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := s.productCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateProduct
+	}
+
+	return &updated, nil
+}
+
+func (s *mongoStore) DeleteProduct(ctx context.Context, productID primitive.ObjectID) error {
+	result, err := s.productCollection.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: productID}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantDeleteProduct
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrProductNotFound
+	}
+
+	return nil
+}
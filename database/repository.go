@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrCantCreateUser   = errors.New("the user was not created")
+	ErrCantUpdateTokens = errors.New("cannot update the user tokens")
+	ErrCantSaveSession  = errors.New("cannot save the session")
+	ErrSessionNotFound  = errors.New("session expired or revoked")
+	ErrRefreshReused    = errors.New("refresh token reuse detected, the session has been revoked")
+	ErrCantAddAddress   = errors.New("cannot add this address")
+	ErrCantEditAddress  = errors.New("cannot edit this address")
+	ErrAddressNotFound  = errors.New("address not found")
+	ErrAddressLimit     = errors.New("address limit reached")
+)
+
+type UserRepository interface {
+	CreateUser(ctx context.Context, user models.User) error
+	FindUserByID(ctx context.Context, userID string) (*models.User, error)
+	FindUserByEmail(ctx context.Context, email string) (*models.User, error)
+	CheckUserAlreadyExist(ctx context.Context, field, value string) (int64, error)
+	UpdateAllTokens(ctx context.Context, userID, signedToken, signedRefreshToken string) error
+	SetUserRole(ctx context.Context, userID, role string) error
+
+	AddSession(ctx context.Context, userID string, session models.Session) error
+	// RotateSession stores the refresh identifier of a renewed session. The swap
+	// only succeeds when oldRefreshID is still the current one, so replaying a
+	// refresh token that was already rotated revokes the whole session.
+	RotateSession(ctx context.Context, userID, oldRefreshID string, session models.Session) error
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+	// RevokeAllSessions logs a user out everywhere: every session is dropped and
+	// the token version is bumped so already issued access tokens stop working.
+	RevokeAllSessions(ctx context.Context, userID string) error
+	SessionActive(ctx context.Context, userID, sessionID string, tokenVersion int) (bool, error)
+
+	ListAddresses(ctx context.Context, userID string) ([]models.Address, error)
+	// AddAddress appends an address unless the user already has limit of
+	// them. The first address becomes the default for shipping and billing.
+	AddAddress(ctx context.Context, userID string, address models.Address, limit int) error
+	// UpdateAddress replaces the address with the same Address_ID, and makes
+	// it the default or no longer the default as its flags say.
+	UpdateAddress(ctx context.Context, userID string, address models.Address) error
+	DeleteAddress(ctx context.Context, userID string, addressID primitive.ObjectID) error
+}
+
+type ProductRepository interface {
+	AddProduct(ctx context.Context, product models.Product) (*models.Product, error)
+	GetProduct(ctx context.Context, productID primitive.ObjectID) (*models.Product, error)
+	ListProducts(ctx context.Context) ([]models.Product, error)
+	SearchProducts(ctx context.Context, name string) ([]models.Product, error)
+	UpdateProduct(ctx context.Context, productID primitive.ObjectID, product models.Product) (*models.Product, error)
+	DeleteProduct(ctx context.Context, productID primitive.ObjectID) error
+}
+
+// InventoryRepository moves units between a product's stock, the
+// reservations held for carts and sold orders. Products without a stock are
+// not tracked and every operation on them succeeds.
+type InventoryRepository interface {
+	// ReserveStock sets the user's hold on a product to quantity units until
+	// expiresAt, taking the difference from or returning it to the stock. A
+	// quantity of zero releases the hold.
+	ReserveStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int, expiresAt time.Time) error
+	// CommitStock takes quantity units for a sale, consuming the user's hold
+	// first and the stock for the rest.
+	CommitStock(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error
+	// RestoreStock puts units taken by CommitStock back on sale.
+	RestoreStock(ctx context.Context, productID primitive.ObjectID, quantity int) error
+	// ReleaseExpiredReservations returns the units of holds that expired
+	// before now to the stock and reports how many holds it released.
+	ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error)
+}
+
+// IdempotencyRepository tracks the Idempotency-Key of requests that must not
+// be applied twice, per user.
+type IdempotencyRepository interface {
+	// ReserveKey claims record.Key for a request. If the key is already
+	// claimed and has not expired, the existing record is returned instead.
+	ReserveKey(ctx context.Context, record models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	// CompleteKey stores the response to replay for a claimed key.
+	CompleteKey(ctx context.Context, userID, key string, status int, contentType string, body []byte) error
+	// ReleaseKey frees a claimed key whose request did not complete.
+	ReleaseKey(ctx context.Context, userID, key string) error
+}
+
+// Cart is a snapshot of a user's cart. Version changes with every cart
+// mutation and guards checkout against concurrent edits.
+type Cart struct {
+	Items   []models.ProductUser
+	Version int
+}
+
+type CartRepository interface {
+	AddProductToCart(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error
+	DecrementCartItem(ctx context.Context, productID primitive.ObjectID, userID string, quantity int) error
+	SetCartItemQuantity(ctx context.Context, userID string, item models.ProductUser, quantity, maxQuantity int) error
+	RemoveCartItem(ctx context.Context, productID primitive.ObjectID, userID string) error
+	GetCart(ctx context.Context, userID string) (*Cart, error)
+}
+
+type OrderRepository interface {
+	// BuyItemFromCart takes the stock of the order lines as CommitStock does,
+	// stores the order and empties the cart in one step, failing with
+	// ErrCartChanged if the cart is no longer at cartVersion. When a line
+	// cannot be filled nothing is written and the line is returned alongside
+	// the error.
+	BuyItemFromCart(ctx context.Context, userID string, order models.Order, cartVersion int) (*models.ProductUser, error)
+	InstantBuyer(ctx context.Context, userID string, order models.Order) error
+	GetOrder(ctx context.Context, orderID primitive.ObjectID) (*models.Order, error)
+	// ListUserOrders returns one page of a user's orders, newest first, and
+	// how many orders the user has in total.
+	ListUserOrders(ctx context.Context, userID string, skip, limit int) ([]models.Order, int64, error)
+	// UpdateOrderStatus records change on the order, failing with
+	// ErrOrderStatusChanged unless the order is still in status from.
+	UpdateOrderStatus(ctx context.Context, orderID primitive.ObjectID, from string, change models.StatusChange) (*models.Order, error)
+	SetOrderPayment(ctx context.Context, orderID primitive.ObjectID, payment models.Payment) error
+}
+
+// Store bundles the repositories the handlers work against, whichever backend
+// provides them.
+type Store struct {
+	Users       UserRepository
+	Products    ProductRepository
+	Carts       CartRepository
+	Orders      OrderRepository
+	Inventory   InventoryRepository
+	Idempotency IdempotencyRepository
+}
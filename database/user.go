@@ -0,0 +1,333 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fredele20/e-commerce-cart/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (s *mongoStore) CreateUser(ctx context.Context, user models.User) error {
+	_, err := s.userCollection.InsertOne(ctx, user)
+	if err != nil {
+		log.Println(err)
+		return ErrCantCreateUser
+	}
+
+	return nil
+}
+
+func (s *mongoStore) findUser(ctx context.Context, filter bson.M) (*models.User, error) {
+	var user models.User
+	err := s.userCollection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *mongoStore) FindUserByID(ctx context.Context, userID string) (*models.User, error) {
+	return s.findUser(ctx, bson.M{"user_id": userID})
+}
+
+func (s *mongoStore) FindUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	return s.findUser(ctx, bson.M{"email": email})
+}
+
+func (s *mongoStore) CheckUserAlreadyExist(ctx context.Context, field, value string) (count int64, err error) {
+	count, err = s.userCollection.CountDocuments(ctx, bson.M{field: value})
+	if err != nil {
+		log.Println(err)
+		fmt.Printf("Error checking for %v", field)
+		return count, err
+	}
+	return count, err
+}
+
+func (s *mongoStore) UpdateAllTokens(ctx context.Context, userID, signedToken, signedRefreshToken string) error {
+	var updateObj primitive.D
+
+	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
+	updateObj = append(updateObj, bson.E{Key: "referesh_token", Value: signedRefreshToken})
+	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	updateObj = append(updateObj, bson.E{Key: "updated_at", Value: updated_at})
+
+	upsert := true
+
+	filter := bson.M{"user_id": userID}
+	opt := options.UpdateOptions{
+		Upsert: &upsert,
+	}
+	_, err := s.userCollection.UpdateOne(ctx, filter, bson.D{
+		{Key: "$set", Value: updateObj},
+	}, &opt)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateTokens
+	}
+
+	return nil
+}
+
+func (s *mongoStore) SetUserRole(ctx context.Context, userID, role string) error {
+	result, err := s.userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$set": bson.M{"role": role}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *mongoStore) AddSession(ctx context.Context, userID string, session models.Session) error {
+	filter := bson.M{"user_id": userID}
+
+	_, err := s.userCollection.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"sessions": bson.M{"expires_at": bson.M{"$lt": time.Now()}}}})
+	if err != nil {
+		log.Println(err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx, filter, bson.M{"$push": bson.M{"sessions": session}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveSession
+	}
+
+	return nil
+}
+
+func (s *mongoStore) RotateSession(ctx context.Context, userID, oldRefreshID string, session models.Session) error {
+	sessionID := session.Session_ID
+	filter := bson.M{
+		"user_id":  userID,
+		"sessions": bson.M{"$elemMatch": bson.M{"session_id": sessionID, "refresh_id": oldRefreshID, "expires_at": bson.M{"$gt": time.Now()}}},
+	}
+	update := bson.M{"$set": bson.M{
+		"sessions.$.refresh_id": session.Refresh_ID,
+		"sessions.$.expires_at": session.Expires_At,
+	}}
+
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveSession
+	}
+
+	if result.MatchedCount == 1 {
+		return nil
+	}
+
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{"user_id": userID, "sessions.session_id": sessionID})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveSession
+	}
+
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+
+	if err := s.RevokeSession(ctx, userID, sessionID); err != nil {
+		return err
+	}
+
+	return ErrRefreshReused
+}
+
+func (s *mongoStore) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	_, err := s.userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.M{"$pull": bson.M{"sessions": bson.M{"session_id": sessionID}}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveSession
+	}
+
+	return nil
+}
+
+func (s *mongoStore) RevokeAllSessions(ctx context.Context, userID string) error {
+	update := bson.M{
+		"$set": bson.M{"sessions": make([]models.Session, 0)},
+		"$inc": bson.M{"token_version": 1},
+	}
+
+	result, err := s.userCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantSaveSession
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func (s *mongoStore) SessionActive(ctx context.Context, userID, sessionID string, tokenVersion int) (bool, error) {
+	version := bson.M{"token_version": tokenVersion}
+	if tokenVersion == 0 {
+		version = bson.M{"token_version": bson.M{"$in": bson.A{0, nil}}}
+	}
+
+	filter := bson.M{"$and": bson.A{
+		bson.M{"user_id": userID, "sessions.session_id": sessionID},
+		version,
+	}}
+
+	count, err := s.userCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func (s *mongoStore) ListAddresses(ctx context.Context, userID string) ([]models.Address, error) {
+	user, err := s.FindUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user.Addresses(), nil
+}
+
+// clearDefaults stops addressID being the user's default shipping and/or
+// billing address.
+func (s *mongoStore) clearDefaults(ctx context.Context, id, addressID primitive.ObjectID, shipping, billing bool) error {
+	fields := make([]string, 0, 2)
+	if shipping {
+		fields = append(fields, "default_shipping")
+	}
+	if billing {
+		fields = append(fields, "default_billing")
+	}
+
+	for _, field := range fields {
+		filter := bson.M{"_id": id, field: addressID}
+		if _, err := s.userCollection.UpdateOne(ctx, filter, bson.M{"$unset": bson.M{field: ""}}); err != nil {
+			log.Println(err)
+			return ErrCantEditAddress
+		}
+	}
+
+	return nil
+}
+
+func (s *mongoStore) AddAddress(ctx context.Context, userID string, address models.Address, limit int) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdNotValid
+	}
+
+	// the push only matches while the address book has room left
+	filter := bson.M{"_id": id, fmt.Sprintf("address.%d", limit-1): bson.M{"$exists": false}}
+	update := bson.M{"$push": bson.M{"address": address}}
+	defaults := bson.M{}
+	if address.Default_Shipping {
+		defaults["default_shipping"] = address.Address_ID
+	}
+	if address.Default_Billing {
+		defaults["default_billing"] = address.Address_ID
+	}
+	if len(defaults) > 0 {
+		update["$set"] = defaults
+	}
+
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantAddAddress
+	}
+
+	if result.MatchedCount == 0 {
+		if count, _ := s.userCollection.CountDocuments(ctx, bson.M{"_id": id}); count == 0 {
+			return ErrUserNotFound
+		}
+		return ErrAddressLimit
+	}
+
+	for _, field := range []string{"default_shipping", "default_billing"} {
+		filter := bson.M{"_id": id, field: bson.M{"$exists": false}}
+		if _, err := s.userCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{field: address.Address_ID}}); err != nil {
+			log.Println(err)
+			return ErrCantAddAddress
+		}
+	}
+
+	return nil
+}
+
+func (s *mongoStore) UpdateAddress(ctx context.Context, userID string, address models.Address) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdNotValid
+	}
+
+	filter := bson.M{"_id": id, "address._id": address.Address_ID}
+	set := bson.M{
+		"address.$.label":       address.Label,
+		"address.$.house_name":  address.House,
+		"address.$.street_name": address.Street,
+		"address.$.city_name":   address.City,
+		"address.$.state":       address.State,
+		"address.$.pin_code":    address.Pincode,
+		"address.$.country":     address.Country,
+	}
+	if address.Default_Shipping {
+		set["default_shipping"] = address.Address_ID
+	}
+	if address.Default_Billing {
+		set["default_billing"] = address.Address_ID
+	}
+
+	result, err := s.userCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		log.Println(err)
+		return ErrCantEditAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+
+	return s.clearDefaults(ctx, id, address.Address_ID, !address.Default_Shipping, !address.Default_Billing)
+}
+
+func (s *mongoStore) DeleteAddress(ctx context.Context, userID string, addressID primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdNotValid
+	}
+
+	filter := bson.M{"_id": id, "address._id": addressID}
+	update := bson.M{"$pull": bson.M{"address": bson.M{"_id": addressID}}}
+	result, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantEditAddress
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+
+	return s.clearDefaults(ctx, id, addressID, true, true)
+}
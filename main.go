@@ -1,34 +1,81 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
-	"os"
+	"net/http"
+	"os/signal"
+	"syscall"
 
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/container"
 	"github.com/fredele20/e-commerce-cart/core"
-	"github.com/fredele20/e-commerce-cart/database"
-	"github.com/fredele20/e-commerce-cart/middleware"
 	"github.com/fredele20/e-commerce-cart/routes"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	app := core.NewApplication(database.ProductData(database.Client, "products"), database.UserData(database.Client, "users"))
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	c, err := container.New(ctx, cfg)
+	if err != nil {
+		log.Fatalf("cannot start the service: %v", err)
+	}
+
+	app := core.NewApplication(c)
+	go app.ReleaseExpiredReservations(ctx)
 
 	router := gin.New()
 	router.Use(gin.Logger())
 
-	routes.UserRoutes(router)
-	router.Use(middleware.Authentication())
+	routes.UserRoutes(router, app, c)
+	routes.AdminRoutes(router, app, c)
+	routes.CartRoutes(router, app, c)
+
+	srv := &http.Server{
+		Addr:         ":" + cfg.Port,
+		Handler:      router,
+		ReadTimeout:  cfg.Server.ReadTimeout.Std(),
+		WriteTimeout: cfg.Server.WriteTimeout.Std(),
+		IdleTimeout:  cfg.Server.IdleTimeout.Std(),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
 
-	router.GET("/addtocart", app.AddToCart())
-	router.GET("/removeitem", app.RemoveItem())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			_ = c.Close(context.Background())
+			log.Fatalf("server stopped: %v", err)
+		}
+	case <-ctx.Done():
+		log.Println("shutting down, waiting for in-flight requests to finish")
+	}
+	stop()
+
+	// handlers run their database work on their own contexts, bounded by the
+	// request timeout, and the config keeps the grace period at least that
+	// long, so checkouts in flight complete before the connection is closed
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Std())
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("graceful shutdown did not complete: %v", err)
+	}
+
+	if err := c.Close(shutdownCtx); err != nil {
+		log.Printf("cannot close the database connection: %v", err)
+	}
 
-	log.Fatal(router.Run(":" + port))
-}
\ No newline at end of file
+	log.Println("server stopped")
+}
@@ -7,24 +7,49 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func Authentication() gin.HandlerFunc {
+func Authentication(tokenManager *tokens.Manager) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		clientToken := ctx.Request.Header.Get("token")
 		if clientToken == "" {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "no authorization header provided"})
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "no authorization header provided"})
 			ctx.Abort()
 			return
 		}
 
-		claims, err := tokens.ValidateToken(clientToken)
+		claims, err := tokenManager.ValidateToken(clientToken)
 		if err != "" {
-			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err})
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": err})
 			ctx.Abort()
 			return
 		}
 
 		ctx.Set("email", claims.Email)
 		ctx.Set("uid", claims.Uid)
+		ctx.Set("session_id", claims.Session_ID)
+		ctx.Set("roles", claims.Roles)
+		ctx.Next()
+	}
+}
+
+func HasRole(ctx *gin.Context, allowed ...string) bool {
+	for _, role := range ctx.GetStringSlice("roles") {
+		for _, want := range allowed {
+			if role == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func RequireRole(allowed ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !HasRole(ctx, allowed...) {
+			ctx.JSON(http.StatusForbidden, gin.H{"error": "you are not allowed to access this resource"})
+			ctx.Abort()
+			return
+		}
+
 		ctx.Next()
 	}
 }
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Documents written before the struct tags of ProductUser.Product_ID and
+// Address.City were fixed store those fields under the driver's default
+// keys, product_id and city. Decoding falls back to them so old carts,
+// orders and address books keep their product ids and cities.
+
+func (p *ProductUser) UnmarshalBSON(data []byte) error {
+	type plain ProductUser
+	if err := bson.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+
+	if p.Product_ID.IsZero() {
+		if id, ok := bson.Raw(data).Lookup("product_id").ObjectIDOK(); ok {
+			p.Product_ID = id
+		}
+	}
+	return nil
+}
+
+func (a *Address) UnmarshalBSON(data []byte) error {
+	type plain Address
+	if err := bson.Unmarshal(data, (*plain)(a)); err != nil {
+		return err
+	}
+
+	if a.City == nil {
+		if city, ok := bson.Raw(data).Lookup("city").StringValueOK(); ok {
+			a.City = &city
+		}
+	}
+	return nil
+}
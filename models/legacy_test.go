@@ -0,0 +1,40 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLegacyKeysAreDecoded(t *testing.T) {
+	legacyID, currentID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	var user struct {
+		Cart    []ProductUser `bson:"usercart"`
+		Address []Address     `bson:"address"`
+	}
+	data, err := bson.Marshal(bson.M{
+		"usercart": bson.A{
+			bson.M{"product_id": legacyID, "price": 1500},
+			bson.M{"_id": currentID, "product_id": legacyID, "price": 900},
+		},
+		"address": bson.A{
+			bson.M{"_id": legacyID, "city": "Ikeja"},
+			bson.M{"_id": currentID, "city_name": "Lagos", "city": "Ikeja"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bson.Unmarshal(data, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.Cart) != 2 || user.Cart[0].Product_ID != legacyID || user.Cart[0].Price != 1500 || user.Cart[1].Product_ID != currentID {
+		t.Errorf("cart = %+v, want the legacy product id and then the current one", user.Cart)
+	}
+	if len(user.Address) != 2 || user.Address[0].City == nil || *user.Address[0].City != "Ikeja" || user.Address[1].City == nil || *user.Address[1].City != "Lagos" {
+		t.Errorf("addresses = %+v, want the legacy city and then the current one", user.Address)
+	}
+}
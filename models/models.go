@@ -6,6 +6,11 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	RoleAdmin    = "ADMIN"
+	RoleCustomer = "CUSTOMER"
+)
+
 type User struct {
 	ID              primitive.ObjectID `json:"_id" bson:"_id"`
 	First_Name      *string            `json:"first_name" validate:"required,min=2,max=255"`
@@ -18,45 +23,252 @@ type User struct {
 	Created_At      time.Time          `json:"created_at"`
 	Updated_At      time.Time          `json:"updated_at"`
 	User_ID         string             `json:"user_id"`
+	Role            string             `json:"role" bson:"role"`
 	UserCart        []ProductUser      `json:"usercart" bson:"usercart"`
+	Cart_Version    int                `json:"-" bson:"cart_version"`
 	Address_Details []Address          `json:"address" bson:"address"`
-	Order_Status    []Order            `json:"orders" bson:"orders"`
+	// Order_Status only holds orders placed before orders got their own
+	// collection, until the orders migration moves them out.
+	Order_Status  []Order   `json:"-" bson:"orders,omitempty"`
+	Sessions      []Session `json:"-" bson:"sessions"`
+	Token_Version int       `json:"-" bson:"token_version"`
+	// Default_Shipping and Default_Billing hold the Address_ID of the user's
+	// default addresses.
+	Default_Shipping primitive.ObjectID `json:"-" bson:"default_shipping,omitempty"`
+	Default_Billing  primitive.ObjectID `json:"-" bson:"default_billing,omitempty"`
+}
+
+// Addresses returns the user's address book with the default flags set.
+func (u *User) Addresses() []Address {
+	addresses := make([]Address, 0, len(u.Address_Details))
+	for _, address := range u.Address_Details {
+		address.Default_Shipping = !address.Address_ID.IsZero() && address.Address_ID == u.Default_Shipping
+		address.Default_Billing = !address.Address_ID.IsZero() && address.Address_ID == u.Default_Billing
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+type Session struct {
+	Session_ID string    `json:"session_id" bson:"session_id"`
+	Refresh_ID string    `json:"refresh_id" bson:"refresh_id"`
+	Created_At time.Time `json:"created_at" bson:"created_at"`
+	Expires_At time.Time `json:"expires_at" bson:"expires_at"`
 }
 
 type Product struct {
-	Product_ID   primitive.ObjectID `bson:"_id"`
-	Product_Name *string            `json:"product_name"`
-	Price        *uint64            `json:"price"`
-	Rating       *uint8             `json:"rating"`
-	Image        *string            `json:"image"`
+	Product_ID   primitive.ObjectID `json:"_id" bson:"_id"`
+	Product_Name *string            `json:"product_name" validate:"required,min=2,max=255"`
+	Price        *uint64            `json:"price" validate:"required,gt=0"`
+	Rating       *uint8             `json:"rating" validate:"omitempty,max=5"`
+	Image        *string            `json:"image" validate:"required,url"`
+	Max_Quantity int                `json:"max_quantity" bson:"max_quantity,omitempty" validate:"min=0"`
+	// Category picks the tax rate of the product, see config.Pricing.
+	Category string `json:"category" bson:"category,omitempty" validate:"max=50"`
+	// Weight is the shipping weight of one unit, in grams.
+	Weight int `json:"weight" bson:"weight,omitempty" validate:"min=0"`
+	// Stock counts the units still available for sale, units held by cart
+	// reservations excluded. Products without a stock are not tracked.
+	Stock        *int          `json:"stock" bson:"stock,omitempty" validate:"omitempty,min=0"`
+	Reservations []Reservation `json:"-" bson:"reservations,omitempty"`
+}
+
+// Reservation holds units of a product for a user's cart until it expires.
+type Reservation struct {
+	User_ID    string    `json:"user_id" bson:"user_id"`
+	Quantity   int       `json:"quantity" bson:"quantity"`
+	Expires_At time.Time `json:"expires_at" bson:"expires_at"`
+}
+
+// Reserved returns how many units the user holds on the product.
+func (p *Product) Reserved(userID string) (int, bool) {
+	for _, reservation := range p.Reservations {
+		if reservation.User_ID == userID {
+			return reservation.Quantity, true
+		}
+	}
+	return 0, false
 }
 
 type ProductUser struct {
-	Product_ID   primitive.ObjectID `bson:"_id`
+	Product_ID   primitive.ObjectID `json:"_id" bson:"_id"`
 	Product_Name *string            `json:"product_name" bson:"product_name"`
 	Price        int                `json:"price" bson:"price"`
 	Rating       *uint              `json:"rating" bson:"rating"`
 	Image        *string            `json:"image" bson:"image"`
+	Quantity     int                `json:"quantity" bson:"quantity"`
+	Weight       int                `json:"weight" bson:"weight,omitempty"`
+	Category     string             `json:"category" bson:"category,omitempty"`
+	// Tax is only set on the lines of placed orders.
+	Tax *LineTax `json:"tax,omitempty" bson:"tax,omitempty"`
+}
+
+// LineTax is the tax charged on one order line. Taxable is the line amount
+// after its share of the discount, tax excluded.
+type LineTax struct {
+	Region  string  `json:"region" bson:"region"`
+	Rate    float64 `json:"rate" bson:"rate"`
+	Taxable int     `json:"taxable" bson:"taxable"`
+	Amount  int     `json:"amount" bson:"amount"`
+}
+
+func NewProductUser(product Product) ProductUser {
+	item := ProductUser{
+		Product_ID:   product.Product_ID,
+		Product_Name: product.Product_Name,
+		Image:        product.Image,
+		Quantity:     1,
+		Weight:       product.Weight,
+		Category:     product.Category,
+	}
+	if product.Price != nil {
+		item.Price = int(*product.Price)
+	}
+	if product.Rating != nil {
+		rating := uint(*product.Rating)
+		item.Rating = &rating
+	}
+	return item
 }
 
 type Address struct {
-	Address_ID primitive.ObjectID `bson:"_id"`
+	Address_ID primitive.ObjectID `json:"_id" bson:"_id"`
+	Label      string             `json:"label" bson:"label" validate:"max=50"`
 	House      *string            `json:"house_name" bson:"house_name"`
 	Street     *string            `json:"street_name" bson:"street_name"`
-	City       *string            `json:"city_name" bson:"city_name`
+	City       *string            `json:"city_name" bson:"city_name"`
+	State      *string            `json:"state" bson:"state,omitempty"`
 	Pincode    *string            `json:"pin_code" bson:"pin_code"`
+	Country    string             `json:"country" bson:"country,omitempty"`
+	// the defaults are kept on the user, see User.Default_Shipping
+	Default_Shipping bool `json:"default_shipping" bson:"-"`
+	Default_Billing  bool `json:"default_billing" bson:"-"`
 }
 
 type Order struct {
-	Order_ID       primitive.ObjectID `bson:"_id"`
-	Order_Cart     []ProductUser      `json:"order_list" bson:"order_list"`
-	Order_At       time.Time          `json:"order_at" bson:"order_at`
-	Price          int                `json:"total_price" bson:"total_price"`
-	Discount       *int               `json:"discount" bson:"discount"`
-	Payment_Method Payment            `json:"payment_method" bson:"payment_method"`
+	Order_ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	User_ID         string             `json:"user_id" bson:"user_id"`
+	Order_Cart      []ProductUser      `json:"order_list" bson:"order_list"`
+	Order_At        time.Time          `json:"order_at" bson:"order_at"`
+	Subtotal        int                `json:"subtotal" bson:"subtotal"`
+	Discount        *int               `json:"discount" bson:"discount"`
+	Tax             int                `json:"tax" bson:"tax"`
+	Tax_Mode        string             `json:"tax_mode" bson:"tax_mode,omitempty"`
+	Price           int                `json:"total_price" bson:"total_price"`
+	Payment_Method  Payment            `json:"payment_method" bson:"payment_method"`
+	Address         *Address           `json:"address" bson:"address,omitempty"`
+	Shipping        *Shipping          `json:"shipping" bson:"shipping,omitempty"`
+	Status          string             `json:"status" bson:"status"`
+	Status_History  []StatusChange     `json:"status_history" bson:"status_history"`
+	Idempotency_Key string             `json:"-" bson:"idempotency_key,omitempty"`
 }
 
+const (
+	ShippingStandard = "standard"
+	ShippingExpress  = "express"
+)
+
+// Shipping is the delivery option chosen at checkout and what it cost.
+type Shipping struct {
+	Option string `json:"option" bson:"option"`
+	Zone   string `json:"zone" bson:"zone"`
+	Weight int    `json:"weight" bson:"weight"`
+	Days   int    `json:"days" bson:"days"`
+	Cost   int    `json:"cost" bson:"cost"`
+}
+
+// IdempotencyRecord remembers the response to a request sent with an
+// Idempotency-Key so retries of it can be answered the same way.
+type IdempotencyRecord struct {
+	User_ID      string    `bson:"user_id"`
+	Key          string    `bson:"key"`
+	Fingerprint  string    `bson:"fingerprint"`
+	Completed    bool      `bson:"completed"`
+	Status       int       `bson:"status"`
+	Content_Type string    `bson:"content_type"`
+	Body         []byte    `bson:"body"`
+	Created_At   time.Time `bson:"created_at"`
+	Expires_At   time.Time `bson:"expires_at"`
+}
+
+const (
+	OrderPending   = "pending"
+	OrderPaid      = "paid"
+	OrderPacked    = "packed"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderRefunded  = "refunded"
+	// OrderReturnRequested is a delivered order the customer wants to send
+	// back, waiting for an admin to approve (refunded) or reject (delivered).
+	OrderReturnRequested = "return_requested"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+var orderTransitions = map[string][]string{
+	OrderPending:   {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderPacked, OrderCancelled, OrderRefunded},
+	OrderPacked:    {OrderShipped, OrderCancelled, OrderRefunded},
+	OrderShipped:   {OrderDelivered},
+	OrderDelivered: {OrderReturnRequested, OrderRefunded},
+	OrderCancelled: {OrderRefunded},
+	OrderRefunded:  {},
+
+	OrderReturnRequested: {OrderRefunded, OrderDelivered},
+}
+
+// StatusChange records when an order entered a status and, for
+// cancellations and returns, why.
+type StatusChange struct {
+	Status string    `json:"status" bson:"status"`
+	At     time.Time `json:"at" bson:"at"`
+	Reason string    `json:"reason,omitempty" bson:"reason,omitempty"`
+}
+
+// ValidOrderStatus reports whether status is one of the order statuses.
+func ValidOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether an order may move from one status to another.
+func CanTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentStatus returns the order status. Orders placed before statuses were
+// tracked are pending.
+func (o *Order) CurrentStatus() string {
+	if o.Status == "" {
+		return OrderPending
+	}
+	return o.Status
+}
+
+const (
+	PaymentCOD     = "cod"
+	PaymentDigital = "digital"
+)
+
+const (
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentVoided     = "voided"
+	PaymentRefunded   = "refunded"
+)
+
+// Payment says how an order is paid. Digital and COD are kept alongside
+// Method for orders stored before payment methods were selectable.
 type Payment struct {
-	Digital bool
-	COD     bool
+	Digital    bool   `json:"digital" bson:"digital"`
+	COD        bool   `json:"cod" bson:"cod"`
+	Method     string `json:"method" bson:"method,omitempty"`
+	Provider   string `json:"provider,omitempty" bson:"provider,omitempty"`
+	Payment_ID string `json:"payment_id,omitempty" bson:"payment_id,omitempty"`
+	Status     string `json:"status,omitempty" bson:"status,omitempty"`
 }
@@ -0,0 +1,36 @@
+package models
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{OrderPending, OrderPaid, true},
+		{OrderPending, OrderCancelled, true},
+		{OrderPending, OrderShipped, false},
+		{OrderPending, OrderRefunded, false},
+		{OrderPaid, OrderPacked, true},
+		{OrderPaid, OrderRefunded, true},
+		{OrderPacked, OrderShipped, true},
+		{OrderShipped, OrderCancelled, false},
+		{OrderShipped, OrderDelivered, true},
+		{OrderDelivered, OrderReturnRequested, true},
+		{OrderReturnRequested, OrderRefunded, true},
+		{OrderReturnRequested, OrderDelivered, true},
+		{OrderReturnRequested, OrderCancelled, false},
+		{OrderCancelled, OrderRefunded, true},
+		{OrderCancelled, OrderPending, false},
+		{OrderRefunded, OrderPending, false},
+		{OrderPaid, OrderPaid, false},
+		{"lost", OrderPending, false},
+		{OrderPending, "lost", false},
+	}
+
+	for _, test := range tests {
+		if got := CanTransition(test.from, test.to); got != test.want {
+			t.Errorf("CanTransition(%s, %s) = %v, want %v", test.from, test.to, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+// FakeDeclineToken makes the fake provider decline an authorization.
+const FakeDeclineToken = "tok_declined"
+
+const fakePrefix = "fake_"
+
+// Fake is a deterministic offline provider: every authorization succeeds
+// except those paid with FakeDeclineToken, payment ids are derived from the
+// order, and webhooks are signed with HMAC-SHA256 of the body.
+type Fake struct {
+	webhookSecret []byte
+}
+
+func NewFake(webhookSecret string) *Fake {
+	return &Fake{webhookSecret: []byte(webhookSecret)}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Authorize(ctx context.Context, req AuthorizeRequest) (*Transaction, error) {
+	if req.Token == FakeDeclineToken || req.Amount <= 0 {
+		return nil, ErrDeclined
+	}
+	return &Transaction{Payment_ID: fakePrefix + req.Order_ID, Amount: req.Amount}, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, paymentID string, amount int) (*Transaction, error) {
+	if !strings.HasPrefix(paymentID, fakePrefix) {
+		return nil, ErrUnknownPayment
+	}
+	return &Transaction{Payment_ID: paymentID, Amount: amount}, nil
+}
+
+func (f *Fake) Refund(ctx context.Context, paymentID string, amount int) (*Transaction, error) {
+	if !strings.HasPrefix(paymentID, fakePrefix) {
+		return nil, ErrUnknownPayment
+	}
+	return &Transaction{Payment_ID: paymentID, Amount: amount}, nil
+}
+
+// Sign returns the signature the fake provider sends with payload, so local
+// tools can simulate webhooks.
+func (f *Fake) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, f.webhookSecret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (*Event, error) {
+	if !hmac.Equal([]byte(f.Sign(payload)), []byte(signature)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil || event.Type == "" || event.Payment_ID == "" {
+		return nil, ErrInvalidEvent
+	}
+	return &event, nil
+}
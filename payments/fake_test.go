@@ -0,0 +1,75 @@
+package payments
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+)
+
+func TestFakeMovesMoney(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("secret")
+
+	authorized, err := fake.Authorize(ctx, AuthorizeRequest{Order_ID: "order-1", Amount: 1500, Token: "tok_visa"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorized.Payment_ID == "" || authorized.Amount != 1500 {
+		t.Errorf("authorized %+v, want 1500 under a payment id", authorized)
+	}
+
+	if _, err := fake.Authorize(ctx, AuthorizeRequest{Order_ID: "order-2", Amount: 1500, Token: FakeDeclineToken}); err != ErrDeclined {
+		t.Errorf("declined token: err = %v, want %v", err, ErrDeclined)
+	}
+	if _, err := fake.Authorize(ctx, AuthorizeRequest{Order_ID: "order-3", Amount: 0}); err != ErrDeclined {
+		t.Errorf("zero amount: err = %v, want %v", err, ErrDeclined)
+	}
+
+	if _, err := fake.Capture(ctx, authorized.Payment_ID, 1500); err != nil {
+		t.Errorf("capture: %v", err)
+	}
+	if _, err := fake.Refund(ctx, authorized.Payment_ID, 1500); err != nil {
+		t.Errorf("refund: %v", err)
+	}
+	if _, err := fake.Capture(ctx, "other_1", 1500); err != ErrUnknownPayment {
+		t.Errorf("capture of an unknown payment: err = %v, want %v", err, ErrUnknownPayment)
+	}
+	if _, err := fake.Refund(ctx, "other_1", 1500); err != ErrUnknownPayment {
+		t.Errorf("refund of an unknown payment: err = %v, want %v", err, ErrUnknownPayment)
+	}
+}
+
+func TestFakeVerifyWebhook(t *testing.T) {
+	fake := NewFake("secret")
+	payload := []byte(`{"type":"payment.captured","payment_id":"fake_1","order_id":"1"}`)
+
+	event, err := fake.VerifyWebhook(payload, fake.Sign(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventCaptured || event.Payment_ID != "fake_1" || event.Order_ID != "1" {
+		t.Errorf("event = %+v", event)
+	}
+
+	if _, err := fake.VerifyWebhook(payload, NewFake("other").Sign(payload)); err != ErrInvalidSignature {
+		t.Errorf("foreign signature: err = %v, want %v", err, ErrInvalidSignature)
+	}
+
+	empty := []byte(`{"type":"payment.captured"}`)
+	if _, err := fake.VerifyWebhook(empty, fake.Sign(empty)); err != ErrInvalidEvent {
+		t.Errorf("event without a payment: err = %v, want %v", err, ErrInvalidEvent)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if provider, err := New(config.Payments{}); provider != nil || err != nil {
+		t.Errorf("no provider: got %v, %v", provider, err)
+	}
+	if provider, err := New(config.Payments{Provider: "fake", WebhookSecret: "secret"}); err != nil || provider.Name() != "fake" {
+		t.Errorf("fake provider: got %v, %v", provider, err)
+	}
+	if _, err := New(config.Payments{Provider: "paypal"}); err == nil {
+		t.Error("an unknown provider was accepted")
+	}
+}
@@ -0,0 +1,72 @@
+// Package payments abstracts the payment gateway digital orders are paid
+// through.
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fredele20/e-commerce-cart/config"
+)
+
+var (
+	ErrDeclined         = errors.New("the payment was declined")
+	ErrUnknownPayment   = errors.New("unknown payment")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrInvalidEvent     = errors.New("invalid webhook event")
+)
+
+// Webhook event types.
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+type AuthorizeRequest struct {
+	Order_ID string
+	Amount   int
+	// Token is the payment instrument collected by the client.
+	Token string
+}
+
+type Transaction struct {
+	Payment_ID string
+	Amount     int
+}
+
+// Event is a verified notification from the provider about a payment.
+type Event struct {
+	Type       string `json:"type"`
+	Payment_ID string `json:"payment_id"`
+	Order_ID   string `json:"order_id"`
+}
+
+// Provider is a payment gateway. Amounts are in the same unit as product
+// prices.
+type Provider interface {
+	Name() string
+	// Authorize reserves the amount on the customer's payment instrument.
+	Authorize(ctx context.Context, req AuthorizeRequest) (*Transaction, error)
+	// Capture collects a previously authorized amount.
+	Capture(ctx context.Context, paymentID string, amount int) (*Transaction, error)
+	// Refund gives back a captured amount, or releases an authorization that
+	// was never captured.
+	Refund(ctx context.Context, paymentID string, amount int) (*Transaction, error)
+	// VerifyWebhook checks that a webhook came from the provider and decodes
+	// its event.
+	VerifyWebhook(payload []byte, signature string) (*Event, error)
+}
+
+// New builds the configured provider. It returns nil when digital payments
+// are disabled.
+func New(cfg config.Payments) (Provider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case "fake":
+		return NewFake(cfg.WebhookSecret), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+}
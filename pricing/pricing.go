@@ -0,0 +1,109 @@
+package pricing
+
+import (
+	"math"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+	"github.com/fredele20/e-commerce-cart/tax"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Line struct {
+	Product_ID   primitive.ObjectID `json:"product_id"`
+	Product_Name *string            `json:"product_name"`
+	Image        *string            `json:"image"`
+	Unit_Price   int                `json:"unit_price"`
+	Quantity     int                `json:"quantity"`
+	Line_Total   int                `json:"line_total"`
+	Category     string             `json:"category"`
+	Tax          models.LineTax     `json:"tax"`
+}
+
+// Quote is the priced view of one user's cart. All amounts are in the same
+// unit as product prices. In inclusive tax mode the tax is part of the
+// subtotal and not added to the total.
+type Quote struct {
+	Lines      []Line `json:"lines"`
+	Item_Count int    `json:"item_count"`
+	Subtotal   int    `json:"subtotal"`
+	Discount   int    `json:"discount"`
+	Tax        int    `json:"tax"`
+	Tax_Mode   string `json:"tax_mode"`
+	Total      int    `json:"total"`
+}
+
+type Calculator struct {
+	discounts []config.Discount
+	tax       *tax.Calculator
+}
+
+func NewCalculator(cfg config.Pricing) *Calculator {
+	return &Calculator{
+		discounts: cfg.Discounts,
+		tax:       tax.NewCalculator(cfg),
+	}
+}
+
+func percentOf(amount int, percent float64) int {
+	return int(math.Round(float64(amount) * percent / 100))
+}
+
+// Quote prices the given cart items shipped to address, which may be nil
+// before one is chosen: line totals, the subtotal, the best discount the
+// subtotal qualifies for, the tax of every line on its share of the
+// discounted amount and the grand total.
+func (c *Calculator) Quote(items []models.ProductUser, address *models.Address) Quote {
+	quote := Quote{Lines: make([]Line, 0, len(items)), Tax_Mode: c.tax.Mode()}
+
+	for _, item := range items {
+		line := Line{
+			Product_ID:   item.Product_ID,
+			Product_Name: item.Product_Name,
+			Image:        item.Image,
+			Unit_Price:   item.Price,
+			Quantity:     item.Quantity,
+			Category:     item.Category,
+		}
+		if line.Quantity <= 0 {
+			line.Quantity = 1
+		}
+		line.Line_Total = line.Unit_Price * line.Quantity
+
+		quote.Lines = append(quote.Lines, line)
+		quote.Item_Count += line.Quantity
+		quote.Subtotal += line.Line_Total
+	}
+
+	var best float64
+	for _, discount := range c.discounts {
+		if quote.Subtotal >= discount.MinSubtotal && discount.Percent > best {
+			best = discount.Percent
+		}
+	}
+	quote.Discount = percentOf(quote.Subtotal, best)
+
+	// spread the discount over the lines by their share of the subtotal, the
+	// last line taking the rounding remainder
+	remaining := quote.Discount
+	for i := range quote.Lines {
+		line := &quote.Lines[i]
+		share := remaining
+		if i < len(quote.Lines)-1 && quote.Subtotal > 0 {
+			share = int(math.Round(float64(quote.Discount) * float64(line.Line_Total) / float64(quote.Subtotal)))
+		}
+		remaining -= share
+
+		region, rate := c.tax.Rate(address, line.Category)
+		taxable, amount := c.tax.Split(line.Line_Total-share, rate)
+		line.Tax = models.LineTax{Region: region, Rate: rate, Taxable: taxable, Amount: amount}
+		quote.Tax += amount
+	}
+
+	quote.Total = quote.Subtotal - quote.Discount
+	if quote.Tax_Mode != config.TaxInclusive {
+		quote.Total += quote.Tax
+	}
+
+	return quote
+}
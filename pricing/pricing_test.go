@@ -0,0 +1,103 @@
+package pricing
+
+import (
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+func TestQuote(t *testing.T) {
+	book := models.ProductUser{Price: 1000, Quantity: 1, Category: "books"}
+	lamp := models.ProductUser{Price: 1000, Quantity: 2}
+
+	tests := []struct {
+		name      string
+		mode      string
+		items     []models.ProductUser
+		discount  int
+		lineTaxes []models.LineTax
+		tax       int
+		total     int
+	}{
+		{
+			name:     "exclusive tax comes on top of the discounted lines",
+			mode:     config.TaxExclusive,
+			items:    []models.ProductUser{book, lamp},
+			discount: 300,
+			lineTaxes: []models.LineTax{
+				{Region: "default", Rate: 5, Taxable: 900, Amount: 45},
+				{Region: "default", Rate: 10, Taxable: 1800, Amount: 180},
+			},
+			tax:   225,
+			total: 2925,
+		},
+		{
+			name:     "inclusive tax is taken out of the discounted lines",
+			mode:     config.TaxInclusive,
+			items:    []models.ProductUser{book, lamp},
+			discount: 300,
+			lineTaxes: []models.LineTax{
+				{Region: "default", Rate: 5, Taxable: 857, Amount: 43},
+				{Region: "default", Rate: 10, Taxable: 1636, Amount: 164},
+			},
+			tax:   207,
+			total: 2700,
+		},
+		{
+			name:     "the last line takes the rounding remainder of the discount",
+			mode:     config.TaxExclusive,
+			items:    []models.ProductUser{{Price: 333, Quantity: 1}, {Price: 333, Quantity: 1}, {Price: 334, Quantity: 1}},
+			discount: 100,
+			lineTaxes: []models.LineTax{
+				{Region: "default", Rate: 10, Taxable: 300, Amount: 30},
+				{Region: "default", Rate: 10, Taxable: 300, Amount: 30},
+				{Region: "default", Rate: 10, Taxable: 300, Amount: 30},
+			},
+			tax:   90,
+			total: 990,
+		},
+		{
+			name:      "no discount below the threshold",
+			mode:      config.TaxExclusive,
+			items:     []models.ProductUser{{Price: 500}},
+			lineTaxes: []models.LineTax{{Region: "default", Rate: 10, Taxable: 500, Amount: 50}},
+			tax:       50,
+			total:     550,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calculator := NewCalculator(config.Pricing{
+				TaxRate:       10,
+				TaxMode:       test.mode,
+				TaxCategories: map[string]float64{"books": 5},
+				Discounts:     []config.Discount{{MinSubtotal: 1000, Percent: 10}},
+			})
+
+			quote := calculator.Quote(test.items, nil)
+
+			if quote.Discount != test.discount {
+				t.Errorf("discount = %d, want %d", quote.Discount, test.discount)
+			}
+			if len(quote.Lines) != len(test.lineTaxes) {
+				t.Fatalf("got %d lines, want %d", len(quote.Lines), len(test.lineTaxes))
+			}
+			for i, line := range quote.Lines {
+				if line.Tax != test.lineTaxes[i] {
+					t.Errorf("line %d tax = %+v, want %+v", i, line.Tax, test.lineTaxes[i])
+				}
+			}
+			if quote.Tax != test.tax {
+				t.Errorf("tax = %d, want %d", quote.Tax, test.tax)
+			}
+			if quote.Total != test.total {
+				t.Errorf("total = %d, want %d", quote.Total, test.total)
+			}
+			if quote.Tax_Mode != test.mode {
+				t.Errorf("tax mode = %q, want %q", quote.Tax_Mode, test.mode)
+			}
+		})
+	}
+}
@@ -1,15 +1,57 @@
 package routes
 
 import (
+	"github.com/fredele20/e-commerce-cart/container"
 	"github.com/fredele20/e-commerce-cart/core"
+	"github.com/fredele20/e-commerce-cart/middleware"
+	"github.com/fredele20/e-commerce-cart/models"
 	"github.com/gin-gonic/gin"
 )
 
+func UserRoutes(incomingRoutes *gin.Engine, app *core.Application, c *container.Container) {
+	incomingRoutes.POST("/users/signup", app.Signup())
+	incomingRoutes.POST("/users/login", app.Login())
+	incomingRoutes.POST("/users/refresh", app.RefreshToken())
+	incomingRoutes.POST("/users/logout", middleware.Authentication(c.Tokens), app.Logout())
+	incomingRoutes.POST("/users/logout/all", middleware.Authentication(c.Tokens), app.LogoutAll())
+	incomingRoutes.GET("/users/productview", app.SearchProduct())
+	incomingRoutes.GET("/users/search", app.SearchProductByQuery())
+	incomingRoutes.POST("/payments/webhook", app.PaymentWebhook())
+}
 
-func UserRoutes(incomingRoutes *gin.Engine) {
-	incomingRoutes.POST("/users/signup", core.Signup())
-	incomingRoutes.POST("/users/login", core.Login())
-	incomingRoutes.POST("/admin/addproduct", core.ProductViewerAdmin())
-	incomingRoutes.GET("/users/productview", core.SearchProduct())
-	incomingRoutes.GET("/users/search", core.SearchProductByQuery())
-}
\ No newline at end of file
+func AdminRoutes(incomingRoutes *gin.Engine, app *core.Application, c *container.Container) {
+	admin := incomingRoutes.Group("/admin", middleware.Authentication(c.Tokens), middleware.RequireRole(models.RoleAdmin))
+	admin.POST("/addproduct", app.ProductViewerAdmin())
+	admin.GET("/products", app.ListProductsAdmin())
+	admin.GET("/products/:id", app.GetProductAdmin())
+	admin.PUT("/products/:id", app.UpdateProductAdmin())
+	admin.DELETE("/products/:id", app.DeleteProductAdmin())
+	admin.PUT("/users/:id/role", app.SetUserRole())
+	admin.POST("/users/:id/logout", app.ForceLogout())
+	admin.GET("/orders/:id", app.GetOrderAdmin())
+	admin.PUT("/orders/:id/status", app.UpdateOrderStatusAdmin())
+	admin.POST("/orders/:id/return/approve", app.ApproveReturnAdmin())
+	admin.POST("/orders/:id/return/reject", app.RejectReturnAdmin())
+}
+
+func CartRoutes(incomingRoutes *gin.Engine, app *core.Application, c *container.Container) {
+	cart := incomingRoutes.Group("/", middleware.Authentication(c.Tokens), middleware.RequireRole(models.RoleCustomer, models.RoleAdmin))
+	cart.GET("/addtocart", app.AddToCart())
+	cart.GET("/removeitem", app.RemoveItem())
+	cart.GET("/cart", app.GetItemFromCart())
+	cart.POST("/cart/items/:id/increment", app.IncrementCartItem())
+	cart.POST("/cart/items/:id/decrement", app.DecrementCartItem())
+	cart.PUT("/cart/items/:id", app.SetCartItemQuantity())
+	cart.GET("/cartcheckout", app.Idempotent(), app.BuyFromCart())
+	cart.GET("/instantbuy", app.Idempotent(), app.InstantBuy())
+	cart.GET("/shipping/options", app.ShippingOptions())
+	cart.GET("/addresses", app.ListAddresses())
+	cart.POST("/addresses", app.AddAddress())
+	cart.GET("/addresses/:id", app.GetAddress())
+	cart.PUT("/addresses/:id", app.UpdateAddress())
+	cart.DELETE("/addresses/:id", app.DeleteAddress())
+	cart.GET("/orders", app.ListOrders())
+	cart.GET("/orders/:id", app.GetOrder())
+	cart.POST("/orders/:id/cancel", app.CancelOrder())
+	cart.POST("/orders/:id/return", app.RequestReturn())
+}
@@ -0,0 +1,136 @@
+// Package shipping prices delivery from the zone and weight rate table in the
+// configuration.
+package shipping
+
+import (
+	"errors"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+var (
+	ErrNoZone            = errors.New("we do not ship to this country")
+	ErrTooHeavy          = errors.New("the order is too heavy to ship with this option")
+	ErrOptionUnavailable = errors.New("this shipping option is not offered for the address")
+)
+
+// Option is one way the items can be delivered to an address.
+type Option struct {
+	Name  string `json:"name"`
+	Days  int    `json:"days"`
+	Price int    `json:"price"`
+}
+
+// Options is what the rate table offers for one parcel.
+type Options struct {
+	Zone    string   `json:"zone"`
+	Weight  int      `json:"weight"`
+	Options []Option `json:"options"`
+}
+
+type Calculator struct {
+	zones []config.ShippingZone
+}
+
+func NewCalculator(cfg config.Shipping) *Calculator {
+	return &Calculator{zones: cfg.Zones}
+}
+
+// Weight is the total weight of the items in grams.
+func Weight(items []models.ProductUser) int {
+	var weight int
+	for _, item := range items {
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		weight += item.Weight * quantity
+	}
+	return weight
+}
+
+func (c *Calculator) zone(country string) (*config.ShippingZone, error) {
+	var fallback *config.ShippingZone
+	for i, zone := range c.zones {
+		if len(zone.Countries) == 0 {
+			fallback = &c.zones[i]
+		}
+		for _, listed := range zone.Countries {
+			if listed == country {
+				return &c.zones[i], nil
+			}
+		}
+	}
+
+	if fallback == nil {
+		return nil, ErrNoZone
+	}
+	return fallback, nil
+}
+
+func price(option config.ShippingOption, weight int) (int, bool) {
+	for _, rate := range option.Rates {
+		if rate.MaxWeight == 0 || weight <= rate.MaxWeight {
+			return rate.Price, true
+		}
+	}
+	return 0, false
+}
+
+// Quote lists the options the items can be shipped with to address, leaving
+// out those whose rates stop below the parcel's weight.
+func (c *Calculator) Quote(address models.Address, items []models.ProductUser) (*Options, error) {
+	weight := Weight(items)
+
+	if len(c.zones) == 0 {
+		return &Options{Weight: weight, Options: []Option{{Name: models.ShippingStandard}}}, nil
+	}
+
+	zone, err := c.zone(address.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &Options{Zone: zone.Name, Weight: weight, Options: make([]Option, 0, len(zone.Options))}
+	for _, option := range zone.Options {
+		if cost, ok := price(option, weight); ok {
+			options.Options = append(options.Options, Option{Name: option.Name, Days: option.Days, Price: cost})
+		}
+	}
+	if len(options.Options) == 0 {
+		return nil, ErrTooHeavy
+	}
+
+	return options, nil
+}
+
+// Choose prices the named option for shipping the items to address.
+func (c *Calculator) Choose(address models.Address, items []models.ProductUser, name string) (*models.Shipping, error) {
+	weight := Weight(items)
+
+	if len(c.zones) == 0 {
+		if name != models.ShippingStandard {
+			return nil, ErrOptionUnavailable
+		}
+		return &models.Shipping{Option: name, Weight: weight}, nil
+	}
+
+	zone, err := c.zone(address.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, option := range zone.Options {
+		if option.Name != name {
+			continue
+		}
+		cost, ok := price(option, weight)
+		if !ok {
+			return nil, ErrTooHeavy
+		}
+		return &models.Shipping{Option: name, Zone: zone.Name, Weight: weight, Days: option.Days, Cost: cost}, nil
+	}
+
+	return nil, ErrOptionUnavailable
+}
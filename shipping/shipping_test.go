@@ -0,0 +1,133 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+var domestic = config.ShippingZone{
+	Name:      "domestic",
+	Countries: []string{"NG"},
+	Options: []config.ShippingOption{
+		{Name: models.ShippingStandard, Days: 5, Rates: []config.ShippingRate{{MaxWeight: 1000, Price: 1500}, {Price: 3000}}},
+		{Name: models.ShippingExpress, Days: 1, Rates: []config.ShippingRate{{MaxWeight: 5000, Price: 5000}}},
+	},
+}
+
+var world = config.ShippingZone{
+	Name: "world",
+	Options: []config.ShippingOption{
+		{Name: models.ShippingStandard, Days: 14, Rates: []config.ShippingRate{{Price: 9000}}},
+	},
+}
+
+func parcel(grams int) []models.ProductUser {
+	return []models.ProductUser{{Weight: grams / 2, Quantity: 2}}
+}
+
+func TestChoose(t *testing.T) {
+	tests := []struct {
+		name     string
+		zones    []config.ShippingZone
+		country  string
+		weight   int
+		option   string
+		shipping *models.Shipping
+		err      error
+	}{
+		{
+			name:     "without zones standard shipping is free",
+			country:  "NG",
+			weight:   4000,
+			option:   models.ShippingStandard,
+			shipping: &models.Shipping{Option: models.ShippingStandard, Weight: 4000},
+		},
+		{
+			name:    "without zones there is no express",
+			country: "NG",
+			option:  models.ShippingExpress,
+			err:     ErrOptionUnavailable,
+		},
+		{
+			name:     "first weight bracket",
+			zones:    []config.ShippingZone{domestic},
+			country:  "NG",
+			weight:   1000,
+			option:   models.ShippingStandard,
+			shipping: &models.Shipping{Option: models.ShippingStandard, Zone: "domestic", Weight: 1000, Days: 5, Cost: 1500},
+		},
+		{
+			name:     "open ended bracket",
+			zones:    []config.ShippingZone{domestic},
+			country:  "NG",
+			weight:   20000,
+			option:   models.ShippingStandard,
+			shipping: &models.Shipping{Option: models.ShippingStandard, Zone: "domestic", Weight: 20000, Days: 5, Cost: 3000},
+		},
+		{
+			name:     "express",
+			zones:    []config.ShippingZone{domestic},
+			country:  "NG",
+			weight:   1600,
+			option:   models.ShippingExpress,
+			shipping: &models.Shipping{Option: models.ShippingExpress, Zone: "domestic", Weight: 1600, Days: 1, Cost: 5000},
+		},
+		{
+			name:    "heavier than the last bracket",
+			zones:   []config.ShippingZone{domestic},
+			country: "NG",
+			weight:  6000,
+			option:  models.ShippingExpress,
+			err:     ErrTooHeavy,
+		},
+		{
+			name:    "country in no zone",
+			zones:   []config.ShippingZone{domestic},
+			country: "GB",
+			option:  models.ShippingStandard,
+			err:     ErrNoZone,
+		},
+		{
+			name:     "zone without countries covers the rest",
+			zones:    []config.ShippingZone{domestic, world},
+			country:  "GB",
+			weight:   200,
+			option:   models.ShippingStandard,
+			shipping: &models.Shipping{Option: models.ShippingStandard, Zone: "world", Weight: 200, Days: 14, Cost: 9000},
+		},
+		{
+			name:    "option the zone does not offer",
+			zones:   []config.ShippingZone{domestic, world},
+			country: "GB",
+			option:  models.ShippingExpress,
+			err:     ErrOptionUnavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			calculator := NewCalculator(config.Shipping{Zones: test.zones})
+			shipping, err := calculator.Choose(models.Address{Country: test.country}, parcel(test.weight), test.option)
+			if err != test.err {
+				t.Fatalf("err = %v, want %v", err, test.err)
+			}
+			if test.shipping != nil && *shipping != *test.shipping {
+				t.Errorf("Choose() = %+v, want %+v", *shipping, *test.shipping)
+			}
+		})
+	}
+}
+
+func TestQuoteLeavesOutOptionsTheParcelIsTooHeavyFor(t *testing.T) {
+	calculator := NewCalculator(config.Shipping{Zones: []config.ShippingZone{domestic}})
+
+	options, err := calculator.Quote(models.Address{Country: "NG"}, parcel(6000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(options.Options) != 1 || options.Options[0].Name != models.ShippingStandard {
+		t.Errorf("options = %+v, want only standard", options.Options)
+	}
+}
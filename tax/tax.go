@@ -0,0 +1,91 @@
+// Package tax works out the tax rate of order lines from the region they ship
+// to and the category of the product, and splits amounts into tax and the
+// rest.
+package tax
+
+import (
+	"math"
+	"strings"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+// DefaultRegion names the rates applied where no configured region matches.
+const DefaultRegion = "default"
+
+type Calculator struct {
+	mode       string
+	rate       float64
+	categories map[string]float64
+	regions    []config.TaxRegion
+}
+
+func NewCalculator(cfg config.Pricing) *Calculator {
+	return &Calculator{
+		mode:       cfg.TaxMode,
+		rate:       cfg.TaxRate,
+		categories: cfg.TaxCategories,
+		regions:    cfg.TaxRegions,
+	}
+}
+
+// Mode is config.TaxExclusive or config.TaxInclusive.
+func (c *Calculator) Mode() string {
+	return c.mode
+}
+
+// region finds the most specific region for address: its state, then its
+// country.
+func (c *Calculator) region(address *models.Address) *config.TaxRegion {
+	if address == nil {
+		return nil
+	}
+
+	var country *config.TaxRegion
+	for i, region := range c.regions {
+		if region.Country != address.Country {
+			continue
+		}
+		if region.State == "" {
+			country = &c.regions[i]
+			continue
+		}
+		if address.State != nil && strings.EqualFold(region.State, *address.State) {
+			return &c.regions[i]
+		}
+	}
+	return country
+}
+
+// Rate returns the percentage charged on products of category shipped to
+// address, which may be nil, and the name of the region it comes from.
+func (c *Calculator) Rate(address *models.Address, category string) (string, float64) {
+	region := c.region(address)
+	if region == nil {
+		if rate, ok := c.categories[category]; ok && category != "" {
+			return DefaultRegion, rate
+		}
+		return DefaultRegion, c.rate
+	}
+
+	name := region.Country
+	if region.State != "" {
+		name += "-" + strings.ToUpper(region.State)
+	}
+	if rate, ok := region.Categories[category]; ok && category != "" {
+		return name, rate
+	}
+	return name, region.Rate
+}
+
+// Split divides the amount charged for a line at rate into the taxable
+// amount and the tax. In exclusive mode the tax comes on top of amount, in
+// inclusive mode it is part of it.
+func (c *Calculator) Split(amount int, rate float64) (taxable, tax int) {
+	if c.mode == config.TaxInclusive {
+		taxable = int(math.Round(float64(amount) * 100 / (100 + rate)))
+		return taxable, amount - taxable
+	}
+	return amount, int(math.Round(float64(amount) * rate / 100))
+}
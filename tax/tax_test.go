@@ -0,0 +1,80 @@
+package tax
+
+import (
+	"testing"
+
+	"github.com/fredele20/e-commerce-cart/config"
+	"github.com/fredele20/e-commerce-cart/models"
+)
+
+func address(country, state string) *models.Address {
+	address := &models.Address{Country: country}
+	if state != "" {
+		address.State = &state
+	}
+	return address
+}
+
+func TestRate(t *testing.T) {
+	calculator := NewCalculator(config.Pricing{
+		TaxRate:       10,
+		TaxMode:       config.TaxExclusive,
+		TaxCategories: map[string]float64{"books": 5},
+		TaxRegions: []config.TaxRegion{
+			{Country: "NG", Rate: 7.5, Categories: map[string]float64{"books": 0}},
+			{Country: "US", State: "CA", Rate: 8},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		address  *models.Address
+		category string
+		region   string
+		rate     float64
+	}{
+		{"no address", nil, "", DefaultRegion, 10},
+		{"no address, category rate", nil, "books", DefaultRegion, 5},
+		{"country rate", address("NG", "Lagos"), "", "NG", 7.5},
+		{"country category rate", address("NG", "Lagos"), "books", "NG", 0},
+		{"unknown category falls back to the region", address("NG", ""), "toys", "NG", 7.5},
+		{"state rate, any casing", address("US", "ca"), "", "US-CA", 8},
+		{"state rate ignores global categories", address("US", "CA"), "books", "US-CA", 8},
+		{"other state of a country with only state regions", address("US", "NY"), "", DefaultRegion, 10},
+		{"country without region", address("GB", ""), "books", DefaultRegion, 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			region, rate := calculator.Rate(test.address, test.category)
+			if region != test.region || rate != test.rate {
+				t.Errorf("Rate() = %s %v, want %s %v", region, rate, test.region, test.rate)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		mode    string
+		amount  int
+		rate    float64
+		taxable int
+		tax     int
+	}{
+		{config.TaxExclusive, 1000, 7.5, 1000, 75},
+		{config.TaxExclusive, 999, 10, 999, 100},
+		{config.TaxExclusive, 1000, 0, 1000, 0},
+		{config.TaxInclusive, 1075, 7.5, 1000, 75},
+		{config.TaxInclusive, 1000, 10, 909, 91},
+		{config.TaxInclusive, 1000, 0, 1000, 0},
+	}
+
+	for _, test := range tests {
+		calculator := NewCalculator(config.Pricing{TaxMode: test.mode})
+		taxable, tax := calculator.Split(test.amount, test.rate)
+		if taxable != test.taxable || tax != test.tax {
+			t.Errorf("%s Split(%d, %v) = %d, %d, want %d, %d", test.mode, test.amount, test.rate, taxable, tax, test.taxable, test.tax)
+		}
+	}
+}
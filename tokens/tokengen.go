@@ -2,64 +2,107 @@ package tokens
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"os"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/fredele20/e-commerce-cart/config"
 	"github.com/fredele20/e-commerce-cart/database"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/fredele20/e-commerce-cart/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
 )
 
 type SignedDetails struct {
-	Email      string
-	First_name string
-	Last_name  string
-	Uid        string
+	Email         string
+	First_name    string
+	Last_name     string
+	Uid           string
+	Roles         []string
+	Session_ID    string
+	Token_Version int
+	Token_Type    string
 	jwt.StandardClaims
 }
 
-var userData *mongo.Collection = database.UserData(database.Client, "Users")
+// Manager signs and validates tokens with the configured keys and lifetimes,
+// checking access tokens against the sessions stored for their user.
+type Manager struct {
+	users           database.UserRepository
+	secretKey       []byte
+	refreshKey      []byte
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	requestTimeout  time.Duration
+}
 
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+func NewManager(cfg *config.Config, users database.UserRepository) *Manager {
+	return &Manager{
+		users:           users,
+		secretKey:       []byte(cfg.JWT.Secret),
+		refreshKey:      []byte(cfg.JWT.RefreshSecret),
+		accessTokenTTL:  cfg.JWT.AccessTTL.Std(),
+		refreshTokenTTL: cfg.JWT.RefreshTTL.Std(),
+		requestTimeout:  cfg.RequestTimeout.Std(),
+	}
+}
 
-func TokenGenerator(email, first_name, last_name, uid string) (signedToken, signedRefereshToken string, err error) {
+func (m *Manager) TokenGenerator(email, first_name, last_name, uid, role string, version int, session models.Session) (signedToken, signedRefereshToken string, err error) {
 	claims := &SignedDetails{
-		Email:      email,
-		First_name: first_name,
-		Last_name:  last_name,
-		Uid:        uid,
+		Email:         email,
+		First_name:    first_name,
+		Last_name:     last_name,
+		Uid:           uid,
+		Roles:         []string{role},
+		Session_ID:    session.Session_ID,
+		Token_Version: version,
+		Token_Type:    AccessToken,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			ExpiresAt: time.Now().Local().Add(m.accessTokenTTL).Unix(),
 		},
 	}
 
 	refreshClaims := &SignedDetails{
+		Uid:           uid,
+		Session_ID:    session.Session_ID,
+		Token_Version: version,
+		Token_Type:    RefreshToken,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id:        session.Refresh_ID,
+			ExpiresAt: session.Expires_At.Unix(),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secretKey)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString(m.refreshKey)
 	if err != nil {
-		log.Panic(err)
+		log.Println(err)
 		return "", "", err
 	}
 
 	return token, refreshToken, nil
 }
 
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+func (m *Manager) parseToken(signedToken, tokenType string) (claims *SignedDetails, msg string) {
+	key := m.secretKey
+	if tokenType == RefreshToken {
+		key = m.refreshKey
+	}
+
 	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, func(t *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return key, nil
 	})
 	if err != nil {
 		msg = err.Error()
@@ -67,7 +110,7 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	}
 
 	claims, ok := token.Claims.(*SignedDetails)
-	if !ok {
+	if !ok || claims.Token_Type != tokenType {
 		msg = "invalid token"
 		return
 	}
@@ -80,29 +123,45 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	return claims, msg
 }
 
-func UpdateAllTokens(signedToken, signedRefreshToken, userid string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-	var updateObj primitive.D
+func (m *Manager) ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = m.parseToken(signedToken, AccessToken)
+	if msg != "" {
+		return nil, msg
+	}
 
-	updateObj = append(updateObj, bson.E{Key: "token", Value: signedToken})
-	updateObj = append(updateObj, bson.E{Key: "refresh_token", Value: signedRefreshToken})
-	updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{Key: "updatedat", Value: updated_at})
+	var ctx, cancel = context.WithTimeout(context.Background(), m.requestTimeout)
+	defer cancel()
 
-	upsert := true
+	active, err := m.users.SessionActive(ctx, claims.Uid, claims.Session_ID, claims.Token_Version)
+	if err != nil {
+		log.Println(err)
+		return nil, "unable to validate token"
+	}
 
-	filter := bson.M{"user_id": userid}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
+	if !active {
+		return nil, "token has been revoked"
 	}
-	_, err := userData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updateObj},
-	}, &opt)
 
-	defer cancel()
-	if err != nil {
-		log.Panic(err)
-		return
+	return claims, msg
+}
+
+func (m *Manager) ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+	return m.parseToken(signedToken, RefreshToken)
+}
+
+func (m *Manager) NewSession() models.Session {
+	now := time.Now()
+	return models.Session{
+		Session_ID: primitive.NewObjectID().Hex(),
+		Refresh_ID: primitive.NewObjectID().Hex(),
+		Created_At: now,
+		Expires_At: now.Add(m.refreshTokenTTL),
 	}
 }
+
+// RenewSession keeps the session id but issues a fresh refresh identifier.
+func (m *Manager) RenewSession(session models.Session) models.Session {
+	session.Refresh_ID = primitive.NewObjectID().Hex()
+	session.Expires_At = time.Now().Add(m.refreshTokenTTL)
+	return session
+}